@@ -0,0 +1,12 @@
+// Package apps holds the default theme: the HTML apps the fetcher writes next
+// to the JSON it generates and, in assets, the files they use. They're
+// embedded so the fetcher works from anywhere.
+package apps
+
+import "embed"
+
+// FS holds every app by file name, like 'shows.html', and the assets below
+// 'assets'.
+//
+//go:embed *.html assets
+var FS embed.FS
@@ -0,0 +1,22 @@
+// createPoster is a link to url showing image, when there is one, and
+// name, like the fetcher renders them.
+function createPoster(url, image, name) {
+  const link = document.createElement('a');
+  link.setAttribute('href', url);
+  if (image && image.medium) {
+    const img = document.createElement('img');
+    img.setAttribute('src', image.medium);
+    img.setAttribute('alt', '');
+    link.appendChild(img);
+  } else {
+    const missing = document.createElement('div');
+    missing.setAttribute('class', 'missing');
+    link.appendChild(missing);
+  }
+  const label = document.createElement('span');
+  label.appendChild(document.createTextNode(name));
+  link.appendChild(label);
+  const item = document.createElement('li');
+  item.appendChild(link);
+  return item;
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// apiRoot is where the versioned JSON API lives.
+const apiRoot = "/api/v1"
+
+// libraryIndex holds the structures the fetcher writes as JSON files, built
+// in memory so the media root can stay read-only. The URLs in them point into
+// the API instead of to the generated files.
+type libraryIndex struct {
+	sync.RWMutex
+	shows []ShowInList
+	// byID is keyed by show directory, which doubles as the ID in the API.
+	byID map[string]*indexedShow
+}
+
+type indexedShow struct {
+	show     SingleShow
+	seasons  map[int]Season
+	episodes map[int]map[int]SingleEpisode
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newLibraryIndex() *libraryIndex {
+	return &libraryIndex{
+		shows: []ShowInList{},
+		byID:  map[string]*indexedShow{},
+	}
+}
+
+// build indexes every show directory of the media root, the working
+// directory, and replaces what was indexed before.
+func (i *libraryIndex) build() error {
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		return err
+	}
+
+	dirs := []string{}
+	for _, file := range files {
+		if file.IsDir() && !isGeneratedDir(file.Name()) {
+			dirs = append(dirs, file.Name())
+		}
+	}
+
+	shows := []ShowInList{}
+	byID := map[string]*indexedShow{}
+	// No artwork is downloaded nor frames grabbed, the media root may be
+	// read-only.
+	for _, result := range processShows(dirs, resolveShow) {
+		show := result.show
+		if show == nil {
+			continue
+		}
+
+		showInList := convertToShowInList(show)
+		showInList.URL = showURL(show.path)
+		shows = append(shows, showInList)
+		byID[show.path] = indexShow(show)
+	}
+
+	i.Lock()
+	i.shows = shows
+	i.byID = byID
+	i.Unlock()
+
+	log.WithField("shows", len(shows)).Info("Library indexed")
+	return nil
+}
+
+func indexShow(show *show) *indexedShow {
+	indexed := &indexedShow{
+		show:     singleShow(show),
+		seasons:  map[int]Season{},
+		episodes: map[int]map[int]SingleEpisode{},
+	}
+	indexed.show.SeasonURLs = []string{}
+
+	for _, number := range seasons(show) {
+		if !hasSeason(show, number) {
+			continue
+		}
+		indexed.show.SeasonURLs = append(indexed.show.SeasonURLs, seasonURL(show.path, number))
+
+		season := season(number, show)
+		for j := range season.Episodes {
+			season.Episodes[j].URL = episodeURL(show.path, number, season.Episodes[j].Number)
+		}
+		indexed.seasons[number] = season
+
+		indexed.episodes[number] = map[int]SingleEpisode{}
+		for _, episode := range episodes(number, show) {
+			indexed.episodes[number][episode.Number] = episode
+		}
+	}
+
+	return indexed
+}
+
+func showURL(dir string) string {
+	return apiRoot + "/shows/" + url.PathEscape(dir)
+}
+
+func seasonURL(dir string, season int) string {
+	return showURL(dir) + "/seasons/" + strconv.Itoa(season)
+}
+
+func episodeURL(dir string, season, episode int) string {
+	return seasonURL(dir, season) + "/episodes/" + strconv.Itoa(episode)
+}
+
+// ServeHTTP answers
+//
+//	/api/v1/shows
+//	/api/v1/shows/{id}
+//	/api/v1/shows/{id}/seasons/{n}
+//	/api/v1/shows/{id}/seasons/{n}/episodes/{n}
+func (i *libraryIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAPI(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiRoot), "/"), "/")
+
+	i.RLock()
+	defer i.RUnlock()
+
+	if len(parts) == 0 || parts[0] != "shows" {
+		writeAPI(w, http.StatusNotFound, apiError{Error: "not found"})
+		return
+	}
+	if len(parts) == 1 {
+		writeAPI(w, http.StatusOK, i.shows)
+		return
+	}
+
+	show, ok := i.byID[parts[1]]
+	if !ok {
+		writeAPI(w, http.StatusNotFound, apiError{Error: "show not found"})
+		return
+	}
+	if len(parts) == 2 {
+		writeAPI(w, http.StatusOK, show.show)
+		return
+	}
+
+	if parts[2] != "seasons" || len(parts) == 3 {
+		writeAPI(w, http.StatusNotFound, apiError{Error: "not found"})
+		return
+	}
+	number, err := strconv.Atoi(parts[3])
+	season, ok := show.seasons[number]
+	if err != nil || !ok {
+		writeAPI(w, http.StatusNotFound, apiError{Error: "season not found"})
+		return
+	}
+	if len(parts) == 4 {
+		writeAPI(w, http.StatusOK, season)
+		return
+	}
+
+	if parts[4] != "episodes" || len(parts) != 6 {
+		writeAPI(w, http.StatusNotFound, apiError{Error: "not found"})
+		return
+	}
+	number, err = strconv.Atoi(parts[5])
+	episode, ok := show.episodes[season.Number][number]
+	if err != nil || !ok {
+		writeAPI(w, http.StatusNotFound, apiError{Error: "episode not found"})
+		return
+	}
+	writeAPI(w, http.StatusOK, episode)
+}
+
+func writeAPI(w http.ResponseWriter, status int, v interface{}) {
+	data, err := encodeJSON(v)
+	if err != nil {
+		log.WithField("err", err).Error("Error encoding API response")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[".json"])
+	w.WriteHeader(status)
+	w.Write(data)
+}
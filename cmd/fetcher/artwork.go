@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // artwork comes in any of these
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// artworkDir is where downloaded artwork and its thumbnails go, in the root
+// of the output. It's no show or film.
+const artworkDir = "_artwork"
+
+// maxArtworkSize is the largest image that is downloaded, anything bigger
+// isn't a poster.
+const maxArtworkSize = 20 << 20
+
+// artworkTimeout bounds a single artwork download.
+var artworkTimeout = 30 * time.Second
+
+var artworkExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// artworkStore keeps track of the artwork made local during a run. Every
+// image is downloaded once, however many shows or episodes use it, and not
+// at all when a previous run left it in the output.
+type artworkStore struct {
+	mutex  sync.Mutex
+	images map[string]*storedArtwork
+}
+
+type storedArtwork struct {
+	once  sync.Once
+	image Image
+	err   error
+}
+
+func newArtworkStore() *artworkStore {
+	return &artworkStore{images: map[string]*storedArtwork{}}
+}
+
+// localize downloads the image at remote, unless it already is in the output,
+// and makes a thumbnail of it. The returned Image points at both.
+func (s *artworkStore) localize(remote string) (Image, error) {
+	s.mutex.Lock()
+	stored, ok := s.images[remote]
+	if !ok {
+		stored = &storedArtwork{}
+		s.images[remote] = stored
+	}
+	s.mutex.Unlock()
+
+	stored.once.Do(func() {
+		stored.image, stored.err = downloadArtwork(remote)
+	})
+	return stored.image, stored.err
+}
+
+// isGeneratedDir tells whether a directory in the root of the output is one
+// the fetcher fills, rather than a show or film.
+func isGeneratedDir(name string) bool {
+	return name == themeAssetsDir || name == artworkDir
+}
+
+// localImage returns image pointing at local copies when -artwork is set.
+// The original is kept for the detail pages and a thumbnail stands in for
+// the medium image in lists. When downloading fails the image is left as
+// is, it still works for whoever is online.
+func localImage(image Image) Image {
+	if !localArtwork {
+		return image
+	}
+
+	remote := image.Original
+	if remote == "" {
+		remote = image.Medium
+	}
+	if !isRemoteURL(remote) {
+		return image
+	}
+
+	local, err := artwork.localize(remote)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"url": remote,
+		}).Warn("Error downloading artwork, linking to it instead")
+		return image
+	}
+	return local
+}
+
+// localizeShowArtwork points the show and its episodes at local artwork.
+// Episodes without any get a frame of their video. Episodes that aren't on
+// disk aren't shown, their artwork is left alone.
+func localizeShowArtwork(show *show) {
+	show.Image = localImage(show.Image)
+
+	dirs := map[int][]string{}
+	for i, episode := range show.Episodes {
+		seasonDirs, ok := dirs[episode.Season]
+		if !ok {
+			seasonDirs = videoDirs(show.path, episode.Season)
+			dirs[episode.Season] = seasonDirs
+		}
+
+		files := seasonVideoFiles(seasonDirs, episode)
+		if len(files) == 0 {
+			continue
+		}
+
+		if episode.Image == (Image{}) {
+			show.Episodes[i].Image = episodeStill(show, episode, files[0])
+			continue
+		}
+		show.Episodes[i].Image = localImage(episode.Image)
+	}
+}
+
+func isRemoteURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// artworkFile names the local copy of remote after its URL, so the same
+// image is only stored once and found again by the next run.
+func artworkFile(remote string) string {
+	extension := ".jpg"
+	if parsed, err := url.Parse(remote); err == nil {
+		if ext := strings.ToLower(path.Ext(parsed.Path)); artworkExtensions[ext] {
+			extension = ext
+		}
+	}
+	return path.Join(artworkDir, fmt.Sprintf("%x", sha1.Sum([]byte(remote)))[:16]+extension)
+}
+
+// artworkURL is the URL of a file in the artwork directory.
+func artworkURL(fileName string) string {
+	return documentRoot + fileName
+}
+
+func downloadArtwork(remote string) (Image, error) {
+	fileName := artworkFile(remote)
+
+	data, err := ioutil.ReadFile(outputPath(fileName))
+	if os.IsNotExist(err) {
+		data, err = fetchArtwork(remote)
+		if err != nil {
+			return Image{}, err
+		}
+		if err := makeOutputDir(artworkDir); err != nil {
+			return Image{}, err
+		}
+		if _, err := writeFile(outputPath(fileName), data); err != nil {
+			return Image{}, err
+		}
+		log.WithFields(log.Fields{
+			"url":  remote,
+			"file": fileName,
+		}).Debug("artwork downloaded")
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	local := Image{
+		Medium:   artworkURL(fileName),
+		Original: artworkURL(fileName),
+	}
+
+	thumbnail, err := writeThumbnail(fileName, data)
+	if err != nil {
+		// The original does for lists too, it's only bigger.
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": fileName,
+		}).Warn("Error making thumbnail")
+		return local, nil
+	}
+	local.Medium = artworkURL(thumbnail)
+	return local, nil
+}
+
+func fetchArtwork(remote string) ([]byte, error) {
+	client := &http.Client{Timeout: artworkTimeout}
+	resp, err := client.Get(remote)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxArtworkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArtworkSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxArtworkSize)
+	}
+	return data, nil
+}
+
+// writeThumbnail scales the image in fileName down to -thumbnail-width,
+// unless it's that small already or a previous run did so. It returns the
+// file to use in lists.
+func writeThumbnail(fileName string, data []byte) (string, error) {
+	thumbnail := fmt.Sprintf("%s-%dw.jpg", strings.TrimSuffix(fileName, path.Ext(fileName)), thumbnailWidth)
+	if _, err := os.Stat(outputPath(thumbnail)); err == nil {
+		return thumbnail, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if thumbnailWidth <= 0 || config.Width <= thumbnailWidth {
+		return fileName, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, scaleDown(src, thumbnailWidth), &jpeg.Options{Quality: 85}); err != nil {
+		return "", err
+	}
+	if _, err := writeFile(outputPath(thumbnail), buffer.Bytes()); err != nil {
+		return "", err
+	}
+	return thumbnail, nil
+}
+
+// scaleDown resizes src to width, keeping its aspect ratio. Every pixel is
+// the average of the pixels it covers, which is good enough for posters and
+// needs nothing outside the standard library.
+func scaleDown(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
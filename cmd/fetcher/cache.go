@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// metadataCache remembers what the metadata provider told us about every show
+// directory so repeated runs don't query it again until an entry goes stale.
+type metadataCache struct {
+	Shows  map[string]cacheEntry      `json:"shows"`
+	Movies map[string]movieCacheEntry `json:"movies"`
+	Probes map[string]probeEntry      `json:"probes"`
+
+	path string
+	ttl  time.Duration
+	// mutex guards the maps, shows are fetched concurrently.
+	mutex sync.Mutex
+}
+
+type cacheEntry struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	// Show is nil when the providers were asked but had no match.
+	Show       *Show  `json:"show"`
+	Provider   string `json:"provider"`
+	ResolvedBy string `json:"resolved_by"`
+	// Pin is the TVMaze ID the show was pinned to when it was fetched.
+	Pin int64 `json:"pin,omitempty"`
+}
+
+type movieCacheEntry struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	// Movie is nil when the providers were asked but had no match.
+	Movie    *Movie `json:"movie"`
+	Provider string `json:"provider"`
+}
+
+func loadCache(fileName string, ttl time.Duration) *metadataCache {
+	cache := &metadataCache{
+		Shows:  map[string]cacheEntry{},
+		Movies: map[string]movieCacheEntry{},
+		Probes: map[string]probeEntry{},
+		path:   fileName,
+		ttl:    ttl,
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return cache
+	}
+	if err != nil {
+		log.WithField("err", err).Warn("failed to read cache, starting empty")
+		return cache
+	}
+
+	if err := json.Unmarshal(data, cache); err != nil {
+		log.WithField("err", err).Warn("failed to decode cache, starting empty")
+		cache.Shows = map[string]cacheEntry{}
+		cache.Movies = map[string]movieCacheEntry{}
+		cache.Probes = map[string]probeEntry{}
+	}
+	// Caches written by older versions lack some of the maps.
+	if cache.Movies == nil {
+		cache.Movies = map[string]movieCacheEntry{}
+	}
+	if cache.Probes == nil {
+		cache.Probes = map[string]probeEntry{}
+	}
+
+	return cache
+}
+
+// lookup returns the cached entry for a show directory if it is fresh enough.
+func (c *metadataCache) lookup(dir string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.Shows[dir]
+	if !ok || time.Since(entry.FetchedAt) > c.ttl {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// lookupStale returns the cached show for a directory however old it is,
+// for when the providers fail.
+func (c *metadataCache) lookupStale(dir string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.Shows[dir]
+	if !ok || entry.Show == nil {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// toShow returns a copy of the cached show that can be changed without
+// changing the cache.
+func (e cacheEntry) toShow(dir string) *show {
+	if e.Show == nil {
+		return nil
+	}
+
+	cached := &show{
+		Show:       *e.Show,
+		path:       dir,
+		provider:   e.Provider,
+		resolvedBy: e.ResolvedBy,
+	}
+	cached.Episodes = append([]Episode{}, e.Show.Episodes...)
+	return cached
+}
+
+func (c *metadataCache) store(dir string, pin int64, found *show) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := cacheEntry{
+		FetchedAt: time.Now(),
+		Pin:       pin,
+	}
+	if found != nil {
+		copied := found.Show
+		copied.Episodes = append([]Episode{}, found.Episodes...)
+		entry.Show = &copied
+		entry.Provider = found.provider
+		entry.ResolvedBy = found.resolvedBy
+	}
+	c.Shows[dir] = entry
+}
+
+// lookupMovie returns the cached entry for a film if it is fresh enough.
+func (c *metadataCache) lookupMovie(fileName string) (movieCacheEntry, bool) {
+	if c == nil {
+		return movieCacheEntry{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.Movies[fileName]
+	if !ok || time.Since(entry.FetchedAt) > c.ttl {
+		return movieCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (e movieCacheEntry) copyMovie() *Movie {
+	if e.Movie == nil {
+		return nil
+	}
+
+	copied := *e.Movie
+	return &copied
+}
+
+func (c *metadataCache) storeMovie(fileName string, movie *Movie, provider string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Movies[fileName] = movieCacheEntry{
+		FetchedAt: time.Now(),
+		Movie:     movieCacheEntry{Movie: movie}.copyMovie(),
+		Provider:  provider,
+	}
+}
+
+// probe returns what was learned about a video file in an earlier run. It's
+// up to the caller to check whether the file changed since.
+func (c *metadataCache) probe(fileName string) (probeEntry, bool) {
+	if c == nil {
+		return probeEntry{}, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.Probes[fileName]
+	return entry, ok
+}
+
+func (c *metadataCache) storeProbe(fileName string, entry probeEntry) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Probes[fileName] = entry
+}
+
+func (c *metadataCache) save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := encodeJSON(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFile(c.path, data)
+	return err
+}
@@ -1,13 +1,9 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"os"
 	"path"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -16,16 +12,32 @@ import (
 type SingleEpisode struct {
 	commonEpisode
 
-	VideoURL     string `json:"video_url"`
-	ShowName     string `json:"show_name"`
-	SeasonNumber int    `json:"season_number"`
+	// VideoURL and VideoType describe the preferred source.
+	VideoURL     string        `json:"video_url"`
+	VideoType    string        `json:"video_type"`
+	Sources      []videoSource `json:"sources"`
+	ShowName     string        `json:"show_name"`
+	SeasonNumber int           `json:"season_number"`
 }
 
-func episodeExists(seasonDir string, episode TvMazeEpisode) bool {
-	if episodeVideoFile(seasonDir, episode) == "" {
-		return false
+// videoSource is one rendition of an episode.
+type videoSource struct {
+	URL        string `json:"url"`
+	Container  string `json:"container"`
+	Type       string `json:"type"`
+	Resolution string `json:"resolution"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Bitrate    int64  `json:"bitrate"`
+}
+
+func episodeExists(dirs []string, episode Episode) bool {
+	for _, dir := range dirs {
+		if episodeVideoFile(dir, episode) != "" {
+			return true
+		}
 	}
-	return true
+	return false
 }
 
 func urlify(name string) string {
@@ -35,88 +47,81 @@ func urlify(name string) string {
 
 func writeEpisodes(show *show) {
 	for _, seasonNumber := range seasons(show) {
-		if _, err := os.Stat(path.Join(show.path, strconv.Itoa(seasonNumber))); err != nil {
-			log.WithFields(log.Fields{
-				"err":    err,
-				"season": seasonNumber,
-				"show":   show.Name,
-				"path":   show.path,
-			}).Warn("season not found on disk, skipping")
-			continue
-		}
+		writeSeasonEpisodes(seasonNumber, show)
+	}
+}
 
-		for _, episode := range episodes(seasonNumber, show) {
-			writeEpisodeJSON(show.path, episode)
-			writeEpisodeApp(show.path, episode)
-		}
+func writeSeasonEpisodes(seasonNumber int, show *show) {
+	if !hasSeason(show, seasonNumber) {
+		log.WithFields(log.Fields{
+			"season": seasonNumber,
+			"show":   show.Name,
+			"path":   show.path,
+		}).Warn("season not found on disk, skipping")
+		return
+	}
+
+	for _, episode := range episodes(seasonNumber, show) {
+		writeEpisodeJSON(show.path, episode)
+		writeEpisodeApp(show.path, episode)
 	}
 }
 
 func writeEpisodeApp(rootPath string, episode SingleEpisode) {
 	episodeDir := path.Join(
-		rootPath,
-		strconv.Itoa(episode.SeasonNumber),
+		seasonOutputDir(rootPath, episode.SeasonNumber),
 		urlify(episode.Name),
 	)
 
-	app, err := os.Create(path.Join(episodeDir, "index.html"))
-	if err != nil {
-		log.WithField("err", err).Error("Error creating index.html in shows root")
-		return
-	}
-	_, err = app.Write(episodeApp)
-	if err != nil {
-		log.WithField("err", err).Error("Error writing index.html in shows root")
+	if err := writeApp(path.Join(episodeDir, "index.html"), episodeApp, episode); err != nil {
+		log.WithField("err", err).Error("Error writing index.html in episode root")
 		return
 	}
 }
 
 func writeEpisodeJSON(rootPath string, episode SingleEpisode) {
 	episodeDir := path.Join(
-		rootPath,
-		strconv.Itoa(episode.SeasonNumber),
+		seasonOutputDir(rootPath, episode.SeasonNumber),
 		urlify(episode.Name),
 	)
 
-	if _, err := os.Stat(episodeDir); err != nil {
-		err := os.Mkdir(episodeDir, 0755)
-		if err != nil {
-			log.WithField("err", err).Error("failed to create episode directory")
-			return
-		}
+	if err := makeOutputDir(episodeDir); err != nil {
+		log.WithField("err", err).Error("failed to create episode directory")
+		return
 	}
 
-	file, err := os.Create(path.Join(
-		episodeDir,
-		"episode.json"),
-	)
+	data, err := encodeJSON(episode)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
 			"dir": episodeDir,
-		}).Warn("failed to create episode.json")
+		}).Warn("failed to encode to episode.json")
 		return
 	}
-	defer file.Close()
 
-	if err := json.NewEncoder(file).Encode(episode); err != nil {
+	fileName := path.Join(episodeDir, "episode.json")
+	written, err := writeFile(outputPath(fileName), data)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
 			"dir": episodeDir,
-		}).Warn("failed to encode to episode.json")
+		}).Warn("failed to write episode.json")
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"file": file.Name(),
-	}).Debug("episode written to disk")
+	if written {
+		log.WithFields(log.Fields{
+			"file": fileName,
+		}).Debug("episode written to disk")
+	}
 }
 
 func episodes(seasonNumber int, show *show) []SingleEpisode {
 	episodes := []SingleEpisode{}
+	dirs := videoDirs(show.path, seasonNumber)
 
-	for _, episode := range show.Embedded.Episodes {
-		if int(episode.Season) != seasonNumber {
+	for _, episode := range show.Episodes {
+		if episode.Season != seasonNumber {
 			log.WithFields(log.Fields{
 				"actual_season":   episode.Season,
 				"required_season": seasonNumber,
@@ -125,25 +130,27 @@ func episodes(seasonNumber int, show *show) []SingleEpisode {
 		}
 
 		// Check if episode exists on disk
-		if !episodeExists(path.Join(show.Name, strconv.Itoa(seasonNumber)), episode) {
+		sources := videoSources(seasonVideoFiles(dirs, episode))
+		if len(sources) == 0 {
 			log.WithFields(log.Fields{
-				"episode": episode.Episode,
+				"episode": episode.Number,
 				"name":    episode.Name,
-				"path":    path.Join(show.Name, strconv.Itoa(seasonNumber)),
+				"path":    strings.Join(dirs, ", "),
 			}).Warn("episode doesn't exists on disk or has the wrong format, skipping")
 			continue
 		}
 
 		episodes = append(episodes, SingleEpisode{
 			commonEpisode: commonEpisode{
-				Number:  int(episode.Episode),
+				Number:  episode.Number,
 				Name:    episode.Name,
 				Summary: episode.Summary,
 				Image:   episode.Image,
 			},
 
-			VideoURL: documentRoot + path.Join(show.path, strconv.Itoa(seasonNumber), episodeVideoFile(
-				path.Join(show.path, strconv.Itoa(seasonNumber)), episode)),
+			VideoURL:     sources[0].URL,
+			VideoType:    sources[0].Type,
+			Sources:      sources,
 			ShowName:     show.Name,
 			SeasonNumber: seasonNumber,
 		})
@@ -152,31 +159,93 @@ func episodes(seasonNumber int, show *show) []SingleEpisode {
 	return episodes
 }
 
-func episodeVideoFile(seasonDir string, episode TvMazeEpisode) string {
-	files, err := ioutil.ReadDir(seasonDir)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"season": episode.Season,
-			"err":    err,
-		}).Error("Error reading season directory")
+// episodeVideoFile returns the preferred video file of an episode in
+// seasonDir.
+func episodeVideoFile(seasonDir string, episode Episode) string {
+	files := episodeVideoFiles(seasonDir, episode)
+	if len(files) == 0 {
+		return ""
 	}
+	return files[0]
+}
 
-	for _, file := range files {
-		if file.IsDir() {
-			log.WithField("file", file.Name()).Debug("looking for video file found dir, skipping")
+// episodeVideoFiles returns every video file of an episode in seasonDir, in
+// order of the container preference.
+func episodeVideoFiles(seasonDir string, episode Episode) []string {
+	extensions := acceptedExtensions()
+	matches := []string{}
+	for _, file := range scans.scan(seasonDir).files {
+		if extensionRank(file.name, extensions) == len(extensions) {
 			continue
 		}
 
-		if strings.Contains(file.Name(), fmt.Sprintf("S%02dE%02d", episode.Season, episode.Episode)) &&
-			strings.HasSuffix(file.Name(), "webm") {
-			log.WithFields(log.Fields{
-				"file":    file.Name(),
-				"episode": episode.Episode,
-				"season":  episode.Season,
-			}).Debug("matched video file with episode")
-			return file.Name()
+		if !file.ok {
+			report.addUnparseable(path.Join(seasonDir, file.name))
+			continue
 		}
+		if !file.parsed.contains(episode) {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"file":    file.name,
+			"episode": episode.Number,
+			"season":  episode.Season,
+		}).Debug("matched video file with episode")
+		matches = append(matches, file.name)
 	}
 
-	return ""
+	sort.SliceStable(matches, func(i, j int) bool {
+		return extensionRank(matches[i], extensions) < extensionRank(matches[j], extensions)
+	})
+
+	return matches
+}
+
+// seasonVideoFiles returns the paths of every video file of an episode in
+// dirs, in order of the container preference.
+func seasonVideoFiles(dirs []string, episode Episode) []string {
+	extensions := acceptedExtensions()
+	files := []string{}
+	for _, dir := range dirs {
+		for _, file := range episodeVideoFiles(dir, episode) {
+			files = append(files, path.Join(dir, file))
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return extensionRank(files[i], extensions) < extensionRank(files[j], extensions)
+	})
+
+	return files
+}
+
+// videoSources describes the video files of an episode. Within a container
+// the highest resolution comes first.
+func videoSources(files []string) []videoSource {
+	extensions := acceptedExtensions()
+	sources := []videoSource{}
+	for _, file := range files {
+		info := probeVideo(file)
+		sources = append(sources, videoSource{
+			URL:        videoURL(file),
+			Container:  strings.TrimPrefix(strings.ToLower(path.Ext(file)), "."),
+			Type:       videoType(file),
+			Resolution: info.resolution(),
+			Width:      info.Width,
+			Height:     info.Height,
+			Bitrate:    info.Bitrate,
+		})
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		rankI := extensionRank(sources[i].URL, extensions)
+		rankJ := extensionRank(sources[j].URL, extensions)
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		return sources[i].Height > sources[j].Height
+	})
+
+	return sources
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parsedFilename is what the name of a video file tells about the episodes
+// in it. Daily shows are recognised by their air date instead.
+type parsedFilename struct {
+	Season   int
+	Episodes []int
+	// AirDate is formatted like TVMaze does, 2016-03-14.
+	AirDate string
+}
+
+// Tags have to stand on their own, so S01E01 matches in 'Name_S01E01_Title'
+// but 720 doesn't in 'S01E01-720p'.
+var (
+	// S01E01, s1e2, S01E100 and multi episode files like S01E01E02,
+	// S01E01-E02, S01E01-02 or S01E01-S01E02.
+	seasonEpisodeTag = regexp.MustCompile(`(?i)(?:^|[^a-z])S(\d{1,3})((?:[ ._-]?E\d{1,3})(?:(?:[ ._-]?E|-S\d{1,3}E|-)\d{1,3})*)(?:[^0-9p]|$)`)
+	episodeNumber    = regexp.MustCompile(`(?i)(-(?:S\d{1,3})?E?|[ ._]?E)(\d{1,3})`)
+	// 1x02, 1x02x03 and 1x02-1x03.
+	crossTag    = regexp.MustCompile(`(?i)(?:^|[^0-9a-z])(\d{1,2})x(\d{2,3})((?:(?:-\d{1,2}x|x)\d{2,3})*)(?:[^0-9p]|$)`)
+	crossNumber = regexp.MustCompile(`(?i)(-\d{1,2}x|x)(\d{2,3})`)
+	// 2016.03.14, 2016-03-14 and the like for daily shows.
+	dateTag = regexp.MustCompile(`(?:^|[^0-9])(\d{4})[. _-](\d{2})[. _-](\d{2})(?:[^0-9]|$)`)
+)
+
+const (
+	airDateLayout = "2006-01-02"
+	// maxRangeLength keeps 'S01E01-99' from turning into a hundred episodes
+	// by accident.
+	maxRangeLength = 50
+)
+
+// parseFilename recognises the episode tags commonly found in video file
+// names.
+func parseFilename(name string) (parsedFilename, bool) {
+	if match := seasonEpisodeTag.FindStringSubmatch(name); match != nil {
+		season, _ := strconv.Atoi(match[1])
+		return parsedFilename{
+			Season:   season,
+			Episodes: episodeNumbers(match[2]),
+		}, true
+	}
+
+	if match := crossTag.FindStringSubmatch(name); match != nil {
+		season, _ := strconv.Atoi(match[1])
+		return parsedFilename{
+			Season:   season,
+			Episodes: episodeNumbers("x" + match[2] + match[3]),
+		}, true
+	}
+
+	if match := dateTag.FindStringSubmatch(name); match != nil {
+		airDate := fmt.Sprintf("%s-%s-%s", match[1], match[2], match[3])
+		if _, err := time.Parse(airDateLayout, airDate); err == nil {
+			return parsedFilename{AirDate: airDate}, true
+		}
+	}
+
+	return parsedFilename{}, false
+}
+
+// episodeNumbers expands the episode part of a tag, 'E01-E03' means episodes
+// one through three.
+func episodeNumbers(tag string) []int {
+	matcher := episodeNumber
+	if strings.HasPrefix(strings.ToLower(tag), "x") {
+		matcher = crossNumber
+	}
+
+	episodes := []int{}
+	for _, match := range matcher.FindAllStringSubmatch(tag, -1) {
+		number, _ := strconv.Atoi(match[2])
+
+		if strings.HasPrefix(match[1], "-") && len(episodes) > 0 {
+			last := episodes[len(episodes)-1]
+			if number > last && number-last <= maxRangeLength {
+				for episode := last + 1; episode < number; episode++ {
+					episodes = append(episodes, episode)
+				}
+			}
+		}
+		episodes = append(episodes, number)
+	}
+	return episodes
+}
+
+// contains tells whether the file holds the given episode.
+func (p parsedFilename) contains(episode Episode) bool {
+	if p.AirDate != "" {
+		return p.AirDate == episode.AirDate
+	}
+
+	if p.Season != episode.Season {
+		return false
+	}
+	for _, number := range p.Episodes {
+		if number == episode.Number {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// compiledSeasonDirs holds -season-dirs compiled, so it's done once and not
+// for every episode. layouts tells what was compiled.
+var compiledSeasonDirs struct {
+	sync.Mutex
+	compiled bool
+	layouts  string
+	patterns []*regexp.Regexp
+	err      error
+}
+
+// seasonDirPatterns returns -season-dirs compiled. Every pattern captures the
+// season number of a season directory name.
+func seasonDirPatterns() ([]*regexp.Regexp, error) {
+	compiledSeasonDirs.Lock()
+	defer compiledSeasonDirs.Unlock()
+
+	if !compiledSeasonDirs.compiled || compiledSeasonDirs.layouts != seasonDirLayouts {
+		compiledSeasonDirs.patterns, compiledSeasonDirs.err = compileSeasonDirs(seasonDirLayouts)
+		compiledSeasonDirs.layouts = seasonDirLayouts
+		compiledSeasonDirs.compiled = true
+	}
+	return compiledSeasonDirs.patterns, compiledSeasonDirs.err
+}
+
+func compileSeasonDirs(layouts string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, layout := range strings.Split(layouts, ",") {
+		layout = strings.TrimSpace(layout)
+		if layout == "" {
+			continue
+		}
+
+		pattern, err := regexp.Compile("(?i)" + layout)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// seasonDirNumber returns the season a directory name stands for.
+func seasonDirNumber(name string, patterns []*regexp.Regexp) (int, bool) {
+	for _, pattern := range patterns {
+		match := pattern.FindStringSubmatch(name)
+		if len(match) < 2 {
+			continue
+		}
+
+		number, err := strconv.Atoi(match[1])
+		if err == nil {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+// seasonDirs returns the directories of a show holding the videos of season,
+// like '1', 'Season 01' or 'S01'.
+func seasonDirs(showPath string, season int) []string {
+	patterns, err := seasonDirPatterns()
+	if err != nil {
+		log.WithField("err", err).Error("Invalid -season-dirs")
+		return nil
+	}
+
+	dirs := []string{}
+	for _, name := range scans.scan(showPath).dirs {
+		if number, ok := seasonDirNumber(name, patterns); ok && number == season {
+			dirs = append(dirs, path.Join(showPath, name))
+		}
+	}
+	return dirs
+}
+
+// videoDirs returns every directory that may hold videos of season. Besides
+// the season directories that's the show directory itself, for shows kept in
+// one flat directory.
+func videoDirs(showPath string, season int) []string {
+	return append(seasonDirs(showPath, season), showPath)
+}
+
+// hasSeason tells whether a season is on disk: it has a season directory or
+// some of its episodes lie in the show directory itself.
+func hasSeason(show *show, season int) bool {
+	if len(seasonDirs(show.path, season)) > 0 {
+		return true
+	}
+
+	for _, episode := range show.Episodes {
+		if episode.Season == season && episodeExists([]string{show.path}, episode) {
+			return true
+		}
+	}
+	return false
+}
+
+// seasonOutputDir is where the season app and its episodes go, whatever the
+// layout of the videos. That keeps the URLs predictable.
+func seasonOutputDir(showPath string, season int) string {
+	return path.Join(showPath, strconv.Itoa(season))
+}
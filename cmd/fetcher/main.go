@@ -2,64 +2,287 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/haarts/showme/apps"
 	"github.com/xrash/smetrics"
 )
 
 var logLevel int
 var documentRoot string
-
-var showsApp []byte
-var showApp []byte
-var seasonApp []byte
-var episodeApp []byte
+var cacheFile string
+var cacheTTL time.Duration
+var providerNames string
+var pinsFile string
+var videoExtensions string
+var ffprobeBinary string
+var seasonDirLayouts string
+var movieProviderNames string
+var outputDir string
+var mediaURL string
+var watch bool
+var watchDelay time.Duration
+var workers int
+var tvmazeTimeout time.Duration
+var tvmazeRetries int
+var failOnError bool
+var fsyncWrites bool
+var themeDir string
+var localArtwork bool
+var thumbnailWidth int
+var ffmpegBinary string
+
+var cache *metadataCache
+var report *runReport
+var scans = newDirScans()
+var artwork = newArtworkStore()
+var pinned = pins{}
+
+var showsApp = builtInApp("shows.html")
+var showApp = builtInApp("show.html")
+var seasonApp = builtInApp("season.html")
+var episodeApp = builtInApp("episode.html")
 
 func init() {
 	const (
-		logLevelUsage = "Set log level (0,1,2,3,4,5, higher is more logging)."
-		documentRootUsage = "Set the document root of the URLs in the to be generated JSON files."
+		logLevelUsage       = "Set log level (0,1,2,3,4,5, higher is more logging)."
+		documentRootUsage   = "Set the document root of the URLs in the to be generated JSON files."
+		cacheFileUsage      = "Cache file, relative to the media path, holding metadata between runs. Empty disables caching."
+		cacheTTLUsage       = "How long cached metadata is trusted before the show is looked up again."
+		providersUsage      = "Comma separated metadata providers (sidecar, nfo, tvmaze), tried in order until one knows the show."
+		pinsFileUsage       = "File, relative to the media path, mapping show directories to TVMaze IDs."
+		extensionsUsage     = "Comma separated video file extensions, most preferred first."
+		ffprobeUsage        = "ffprobe binary used to read resolution and bitrate of videos. Empty disables probing."
+		seasonDirsUsage     = "Comma separated patterns of season directory names, capturing the season number."
+		movieProvidersUsage = "Comma separated movie metadata providers (omdb), tried in order until one knows the film."
+		outputUsage         = "Directory the generated JSON and apps are written to, leaving the media path untouched. Empty writes next to the videos."
+		mediaURLUsage       = "URL prefix of the videos in the generated JSON. Defaults to the document root."
+		watchUsage          = "Keep running and regenerate the shows whose videos change."
+		watchDelayUsage     = "How long changes have to settle before -watch regenerates."
+		workersUsage        = "How many shows are fetched at the same time."
+		tvmazeTimeoutUsage  = "How long a single TVMaze request may take."
+		tvmazeRetriesUsage  = "How often a TVMaze request is retried after a timeout, rate limit or server error."
+		failOnErrorUsage    = "Exit with status 1 when a show lookup failed, even if stale data stood in."
+		fsyncUsage          = "Flush every generated file to disk before moving it into place."
+		themeUsage          = "Theme directory with apps (shows.html, show.html, ...) and an assets directory copied to the output. What it lacks comes from the built in theme."
+		appsDirUsage        = "Deprecated, use -theme."
+		artworkUsage        = "Download the artwork into the output and point the JSON at the copies instead of the provider."
+		thumbnailWidthUsage = "Width of the thumbnails -artwork makes for lists."
+		ffmpegUsage         = "ffmpeg binary used to grab a frame of episodes without artwork. Empty disables grabbing."
 	)
 
 	flag.IntVar(&logLevel, "log-level", int(log.ErrorLevel), logLevelUsage)
 	flag.StringVar(&documentRoot, "document-root", "/", documentRootUsage)
+	flag.StringVar(&cacheFile, "cache", ".showme-cache.json", cacheFileUsage)
+	flag.DurationVar(&cacheTTL, "cache-ttl", 24*time.Hour, cacheTTLUsage)
+	flag.StringVar(&providerNames, "providers", "nfo,tvmaze", providersUsage)
+	flag.StringVar(&pinsFile, "pins", ".showme-pins.json", pinsFileUsage)
+	flag.StringVar(&videoExtensions, "extensions", "webm,mp4,m4v,ogv,mkv", extensionsUsage)
+	flag.StringVar(&ffprobeBinary, "ffprobe", "ffprobe", ffprobeUsage)
+	flag.StringVar(&seasonDirLayouts, "season-dirs", `^(\d+)$,^Season[ ._-]*(\d+)$,^S(\d+)$`, seasonDirsUsage)
+	flag.StringVar(&movieProviderNames, "movie-providers", "omdb", movieProvidersUsage)
+	flag.StringVar(&outputDir, "output", "", outputUsage)
+	flag.StringVar(&mediaURL, "media-url", "", mediaURLUsage)
+	flag.BoolVar(&watch, "watch", false, watchUsage)
+	flag.DurationVar(&watchDelay, "watch-delay", 2*time.Second, watchDelayUsage)
+	flag.IntVar(&workers, "workers", 4, workersUsage)
+	flag.DurationVar(&tvmazeTimeout, "tvmaze-timeout", 10*time.Second, tvmazeTimeoutUsage)
+	flag.IntVar(&tvmazeRetries, "tvmaze-retries", 3, tvmazeRetriesUsage)
+	flag.BoolVar(&failOnError, "fail-on-error", false, failOnErrorUsage)
+	flag.BoolVar(&fsyncWrites, "fsync", false, fsyncUsage)
+	flag.StringVar(&themeDir, "theme", "", themeUsage)
+	flag.StringVar(&themeDir, "apps-dir", "", appsDirUsage)
+	flag.BoolVar(&localArtwork, "artwork", false, artworkUsage)
+	flag.IntVar(&thumbnailWidth, "thumbnail-width", 210, thumbnailWidthUsage)
+	flag.StringVar(&ffmpegBinary, "ffmpeg", "ffmpeg", ffmpegUsage)
 }
 
 type commonEpisode struct {
 	Number  int    `json:"number"`
 	Name    string `json:"name"`
 	Summary string `json:"summary"`
-	Image   struct {
-		Medium   string `json:"medium"`
-		Original string `json:"original"`
-	} `json:"image"`
+	Image   Image  `json:"image"`
 }
 
 type show struct {
-	TvMazeShow
-	path string
+	Show
+	path     string
+	provider string
+	// resolvedBy tells how the show was matched; by pin, search or sidecar.
+	resolvedBy string
+	// stale is set when the providers failed and an expired cache entry
+	// stood in.
+	stale bool
 }
 
+const (
+	resolvedByPin     = "pin"
+	resolvedBySearch  = "search"
+	resolvedBySidecar = "sidecar"
+)
+
 func findMatchingShow(filename string) *show {
+	show, _ := resolveShow(filename)
+	return show
+}
+
+// resolveShow is findMatchingShow telling a show no provider knows, nil
+// without error, from a provider that failed.
+func resolveShow(filename string) (*show, error) {
 	contextLogger := log.WithField("file", filename)
-	tvMaze := TvMazeClient{
-		logger: contextLogger,
+
+	sidecar, err := readSidecar(filename)
+	if err != nil {
+		contextLogger.WithField("err", err).Warn("Ignoring unreadable sidecar")
+	}
+
+	var matched *show
+	if sidecar == nil || !sidecar.Offline {
+		matched, err = lookupShow(filename, contextLogger)
+		if err != nil {
+			if entry, ok := cache.lookupStale(filename); ok {
+				contextLogger.WithField("err", err).Warn("Lookup failed, using stale metadata")
+				matched, err = entry.toShow(filename), nil
+				matched.stale = true
+			}
+		}
+	}
+
+	if matched != nil {
+		if err := overrideFromSidecars(&matched.Show, sidecar, filename); err != nil {
+			contextLogger.WithField("err", err).Warn("Ignoring unreadable episode sidecars")
+		}
+	} else if sidecar != nil && err == nil {
+		// A sidecar only describes the show by itself when no provider
+		// knows it, a failed lookup is no reason to forget the episodes.
+		var found *Show
+		found, err = fetchShow(sidecarProvider{logger: contextLogger}, filename)
+		if found != nil {
+			matched = &show{
+				Show:       *found,
+				path:       filename,
+				provider:   sidecarProvider{}.Name(),
+				resolvedBy: resolvedBySidecar,
+			}
+		}
 	}
 
-	tvMazeShow, err := tvMaze.Find(filename)
-	if err != nil || tvMazeShow == nil || !goodEnoughMatch(filename, tvMazeShow.Name) {
+	if err != nil {
+		contextLogger.WithField("err", err).Warn("Lookup failed")
+		return nil, err
+	}
+	if matched == nil {
 		contextLogger.Debug("No match")
-		return nil
+		return nil, nil
+	}
+	contextLogger.WithFields(log.Fields{
+		"show":        matched.Name,
+		"provider":    matched.provider,
+		"resolved_by": matched.resolvedBy,
+	}).Debug("Found match")
+
+	return matched, nil
+}
+
+func overrideFromSidecars(show *Show, sidecar *sidecar, dir string) error {
+	if sidecar != nil {
+		sidecar.apply(show)
+	}
+
+	episodeSidecars, err := readEpisodeSidecars(dir)
+	if err != nil {
+		return err
 	}
-	contextLogger.WithField("show", tvMazeShow.Name).Debug("Found match")
+	applyEpisodeSidecars(show, episodeSidecars)
 
-	return &show{
-		TvMazeShow: *tvMazeShow,
-		path:       filename,
+	return nil
+}
+
+// lookupShow asks the metadata providers about a show directory. Offline
+// providers are always asked, for the others a fresh answer in the cache
+// does. Pinned directories skip the search.
+func lookupShow(filename string, contextLogger *log.Entry) (*show, error) {
+	pin, isPinned, err := pinned.lookup(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, cached := cache.lookup(filename)
+	cached = cached && entry.Pin == pin
+	if provider, err := newProvider(entry.Provider, nil); err == nil && isOffline(provider) {
+		// Left by a version that cached offline providers too.
+		cached = false
+	}
+
+	if isPinned {
+		if cached {
+			contextLogger.Debug("Using cached metadata")
+			return entry.toShow(filename), nil
+		}
+
+		tvMaze := TvMazeClient{logger: contextLogger}
+		found, err := fetchShow(pinnedProvider{TvMazeClient: tvMaze, id: pin}, filename)
+		if err != nil || found == nil {
+			return nil, err
+		}
+
+		matched := &show{
+			Show:       *found,
+			path:       filename,
+			provider:   tvMaze.Name(),
+			resolvedBy: resolvedByPin,
+		}
+		cache.store(filename, pin, matched)
+		return matched, nil
+	}
+
+	providers, err := newProviders(providerNames, contextLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	asked := false
+	for _, provider := range providers {
+		offline := isOffline(provider)
+		if !offline && cached {
+			// The cache holds what the online providers came up with.
+			if entry.Show != nil {
+				contextLogger.Debug("Using cached metadata")
+				return entry.toShow(filename), nil
+			}
+			continue
+		}
+
+		found, err := fetchShow(provider, filename)
+		if err != nil {
+			return nil, err
+		}
+		if !offline {
+			asked = true
+		}
+		if found != nil {
+			matched := &show{
+				Show:       *found,
+				path:       filename,
+				provider:   provider.Name(),
+				resolvedBy: resolvedBySearch,
+			}
+			if !offline {
+				cache.store(filename, 0, matched)
+			}
+			return matched, nil
+		}
 	}
+	if asked {
+		cache.store(filename, 0, nil)
+	}
+
+	return nil, nil
 }
 
 func goodEnoughMatch(s1, s2 string) bool {
@@ -88,8 +311,8 @@ func unique(list []int) []int {
 
 func seasons(show *show) []int {
 	seasons := []int{}
-	for _, episode := range show.Embedded.Episodes {
-		seasons = append(seasons, int(episode.Season))
+	for _, episode := range show.Episodes {
+		seasons = append(seasons, episode.Season)
 	}
 
 	return unique(seasons)
@@ -97,36 +320,57 @@ func seasons(show *show) []int {
 
 func loadShowsApp() error {
 	var err error
-	showsApp, err = loadApp("apps/shows.html")()
+	showsApp, err = loadTemplate("shows.html")
 	return err
 }
 
 func loadShowApp() error {
 	var err error
-	showApp, err = loadApp("apps/show.html")()
+	showApp, err = loadTemplate("show.html")
 	return err
 }
 
 func loadSeasonApp() error {
 	var err error
-	seasonApp, err = loadApp("apps/season.html")()
+	seasonApp, err = loadTemplate("season.html")
 	return err
 }
 
 func loadEpisodeApp() error {
 	var err error
-	episodeApp, err = loadApp("apps/episode.html")()
+	episodeApp, err = loadTemplate("episode.html")
 	return err
 }
 
-func loadApp(fileName string) func() ([]byte, error) {
+// loadApp returns an app from -theme, or the one built in when the theme
+// doesn't have it.
+func loadApp(name string) func() ([]byte, error) {
 	return func() ([]byte, error) {
-		data, err := ioutil.ReadFile(fileName)
-		if err != nil {
-			log.WithField("err", err).Errorf("Error opening %s", fileName)
-			return nil, err
+		if themeDir != "" {
+			if err := checkThemeDir(); err != nil {
+				return nil, err
+			}
+
+			data, err := ioutil.ReadFile(path.Join(themeDir, name))
+			if err == nil {
+				return data, nil
+			}
+			if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("-theme: %v", err)
+			}
+		}
+
+		return apps.FS.ReadFile(name)
+	}
+}
+
+// loadApps runs the loaders of the apps a subcommand writes. Without its
+// apps the fetcher can't do anything useful, so it exits when one fails.
+func loadApps(loaders ...func() error) {
+	for _, load := range loaders {
+		if err := load(); err != nil {
+			log.WithField("err", err).Fatal("Error loading app")
 		}
-		return data, nil
 	}
 }
 
@@ -134,20 +378,29 @@ func main() {
 	flag.Parse()
 	log.SetLevel(log.Level(logLevel))
 
-	if err := loadShowsApp(); err != nil {
-		return
-	}
-
-	if err := loadShowApp(); err != nil {
-		return
+	switch flag.Arg(0) {
+	case "match":
+		runMatch(flag.Args()[1:])
+	case "movies":
+		runMovies(flag.Args()[1:])
+	case "serve":
+		runServe(flag.Args()[1:])
+	case "prune":
+		runPrune(flag.Args()[1:])
+	default:
+		runFetch(flag.Args())
 	}
+}
 
-	if err := loadSeasonApp(); err != nil {
-		return
+// enterMediaRoot makes the media path the working directory and loads the
+// state kept in it.
+func enterMediaRoot(args []string) {
+	if _, err := newProviders(providerNames, nil); err != nil {
+		log.WithField("err", err).Fatal("Invalid -providers")
 	}
 
-	if err := loadEpisodeApp(); err != nil {
-		return
+	if _, err := seasonDirPatterns(); err != nil {
+		log.WithField("err", err).Fatal("Invalid -season-dirs")
 	}
 
 	dir, err := os.Getwd()
@@ -157,17 +410,66 @@ func main() {
 		}).Fatal("Error getting working directory")
 	}
 
-	if len(flag.Args()) != 1 {
+	if len(args) != 1 {
 		log.Fatal("Require one argument pointing to media path")
 	}
 
-	if err := os.Chdir(path.Join(dir, flag.Args()[0])); err != nil {
+	if outputDir != "" {
+		if !path.IsAbs(outputDir) {
+			outputDir = path.Join(dir, outputDir)
+		}
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			log.WithFields(log.Fields{
+				"err":    err,
+				"output": outputDir,
+			}).Fatal("Error creating output directory")
+		}
+	}
+
+	// The theme is relative to where the fetcher was started too.
+	if themeDir != "" && !path.IsAbs(themeDir) {
+		themeDir = path.Join(dir, themeDir)
+	}
+
+	if err := os.Chdir(path.Join(dir, args[0])); err != nil {
 		log.WithFields(log.Fields{
 			"err":  err,
-			"root": path.Join(dir, flag.Args()[0]),
+			"root": path.Join(dir, args[0]),
 		}).Fatal("Error changing working dir")
 	}
 
+	if cacheFile != "" {
+		// The cache is generated too, it follows the rest of the output.
+		cache = loadCache(outputPath(cacheFile), cacheTTL)
+	}
+
+	if pinned, err = loadPins(pinsFile); err != nil {
+		log.WithField("err", err).Fatal("Error loading pins")
+	}
+}
+
+// generateShow looks up a show directory and writes everything for it. When
+// the lookup fails what was written before is left alone.
+func generateShow(dir string) (*show, error) {
+	show, err := resolveShow(dir)
+	if show != nil {
+		localizeShowArtwork(show)
+		writeShow(show)     // 1x show.json
+		writeSeasons(show)  // Nx season.json
+		writeEpisodes(show) // Mx episode.json
+	}
+	return show, err
+}
+
+func runFetch(args []string) {
+	loadApps(loadShowsApp, loadShowApp, loadSeasonApp, loadEpisodeApp)
+
+	enterMediaRoot(args)
+
+	if err := writeThemeAssets(); err != nil {
+		log.WithField("err", err).Fatal("Error writing theme assets")
+	}
+
 	files, err := ioutil.ReadDir(".")
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -175,23 +477,52 @@ func main() {
 		}).Fatal("Error initializing Fetcher")
 	}
 
-	shows := []ShowInList{}
+	report = newRunReport()
+	dirs := []string{}
 	for _, file := range files {
-		if !file.IsDir() {
+		if !file.IsDir() || isGeneratedDir(file.Name()) {
 			log.WithField("file", file.Name()).Debug("skipping")
 			continue
 		}
+		dirs = append(dirs, file.Name())
+	}
 
-		show := findMatchingShow(file.Name())
-		if show != nil {
-			showInList := convertToShowInList(show)
-			shows = append(shows, showInList)
+	previous := readShowsJSON()
+	library := newWatchedLibrary()
+	shows := []ShowInList{}
+	for _, result := range processShows(dirs, generateShow) {
+		if result.err != nil {
+			// Keep listing the show as it was, rather than dropping it.
+			if showInList, ok := previous[documentRoot+result.dir]; ok {
+				shows = append(shows, showInList)
+				library.kept[result.dir] = showInList
+				report.addStale(result.dir, showInList.Name)
+				continue
+			}
+			report.addFailure(result.dir, result.err)
+			continue
+		}
 
-			writeShow(show)     // 1x show.json
-			writeSeasons(show)  // Nx season.json
-			writeEpisodes(show) // Mx episode.json
+		report.addShow(result.dir, result.show)
+		if result.show != nil {
+			shows = append(shows, convertToShowInList(result.show))
+			library.shows[result.dir] = result.show
 		}
 	}
 
 	writeShows(shows)
+
+	if err := cache.save(); err != nil {
+		log.WithField("err", err).Error("Error saving cache")
+	}
+
+	report.print(os.Stdout)
+
+	if failOnError && report.errored() {
+		os.Exit(1)
+	}
+
+	if watch {
+		watchLibrary(library)
+	}
 }
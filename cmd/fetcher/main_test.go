@@ -1,53 +1,65 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 )
 
-var tvMazeShow = &show{
+func init() {
+	// Tests don't talk to the real TVMaze, no need to wait for it.
+	tvmazeLimiter = rate.NewLimiter(rate.Inf, 1)
+	tvmazeBackoff = time.Millisecond
+}
+
+var testShow = &show{
 	path: "show1",
-	TvMazeShow: TvMazeShow{
+	Show: Show{
 		Name: "show1",
-		Embedded: struct {
-			Episodes []TvMazeEpisode `json:"episodes"`
-		}{
-			Episodes: []TvMazeEpisode{
-				TvMazeEpisode{
-					Name:    "first",
-					Episode: int64(1),
-					Season:  int64(1), // fine, exists on disk
-				},
-				TvMazeEpisode{
-					Name:    "second",
-					Episode: int64(2),
-					Season:  int64(1), // fine, exists on disk
-				},
-				TvMazeEpisode{
-					Name:    "third",
-					Episode: int64(3),
-					Season:  int64(1), // not fine, absent on disk
-				},
-				TvMazeEpisode{
-					Name:    "first in second",
-					Episode: int64(1),
-					Season:  int64(2), // not fine, absent on disk
-				},
-				TvMazeEpisode{
-					Name:    "first in second",
-					Episode: int64(1),
-					Season:  int64(3), // not fine, absent on disk
-				},
+		Episodes: []Episode{
+			Episode{
+				Name:   "first",
+				Number: 1,
+				Season: 1, // fine, exists on disk
+			},
+			Episode{
+				Name:   "second",
+				Number: 2,
+				Season: 1, // fine, exists on disk
+			},
+			Episode{
+				Name:   "third",
+				Number: 3,
+				Season: 1, // not fine, absent on disk
+			},
+			Episode{
+				Name:   "first in second",
+				Number: 1,
+				Season: 2, // not fine, absent on disk
+			},
+			Episode{
+				Name:   "first in second",
+				Number: 1,
+				Season: 3, // not fine, absent on disk
 			},
 		},
 	},
@@ -71,6 +83,463 @@ func TestFindMatchingShowWithoutClearMatch(t *testing.T) {
 	assert.Nil(t, show)
 }
 
+func TestFindMatchingShowFromCache(t *testing.T) {
+	cache = &metadataCache{Shows: map[string]cacheEntry{}, ttl: time.Hour}
+	defer func() { cache = nil }()
+
+	cache.store("show1", 0, testShow)
+	os.Setenv("TVMAZE_URL_TEMPLATE", "http://127.0.0.1:0/%s")
+
+	show := findMatchingShow("show1")
+	require.NotNil(t, show)
+	assert.Equal(t, "show1", show.Name)
+	assert.Len(t, show.Episodes, 5)
+
+	cache.Shows["show1"] = cacheEntry{
+		FetchedAt: time.Now().Add(-2 * time.Hour),
+		Show:      &testShow.Show,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", http.NotFound)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("TVMAZE_URL_TEMPLATE", ts.URL+"/%s")
+	assert.Nil(t, findMatchingShow("show1"), "stale entries should be looked up again")
+
+	// Only when the lookup fails the stale entry stands in.
+	cache.Shows["show1"] = cacheEntry{
+		FetchedAt: time.Now().Add(-2 * time.Hour),
+		Show:      &testShow.Show,
+	}
+	os.Setenv("TVMAZE_URL_TEMPLATE", "http://127.0.0.1:0/%s")
+	show, err := resolveShow("show1")
+	require.NoError(t, err)
+	require.NotNil(t, show)
+	assert.True(t, show.stale)
+	assert.Equal(t, "show1", show.Name)
+
+	report := newRunReport()
+	report.addShow("show1", show)
+	report.addStale("show2", "Show 2")
+	assert.True(t, report.errored())
+	var out bytes.Buffer
+	report.print(&out)
+	assert.Equal(t, "Served from stale data (2):\n  show1 -> show1\n  show2 -> Show 2\n", out.String())
+}
+
+func TestFindMatchingShowByPin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shows/210", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{
+			"id": 210,
+			"name": "Doctor Who",
+			"_embedded": {"episodes": [{"name": "Rose", "season": 1, "number": 1}]}
+		}`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("TVMAZE_SHOW_URL_TEMPLATE", ts.URL+"/shows/%d")
+	os.Setenv("TVMAZE_URL_TEMPLATE", "http://127.0.0.1:0/%s")
+
+	pinned = pins{"Doctor Who (2005)": 210}
+	defer func() { pinned = pins{} }()
+
+	show := findMatchingShow("Doctor Who (2005)")
+	require.NotNil(t, show)
+	assert.Equal(t, "Doctor Who", show.Name)
+	assert.Equal(t, "210", show.ID)
+	assert.Equal(t, resolvedByPin, show.resolvedBy)
+	assert.Equal(t, []Episode{{Season: 1, Number: 1, Name: "Rose"}}, show.Episodes)
+
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, pinFile), []byte("210\n"), 0644))
+
+	id, ok, err := pins{}.lookup(dir)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(210), id)
+}
+
+func TestTvMazeRetries(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/singlesearch/shows/flaky", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprintln(w, `{"id": 1, "name": "flaky"}`)
+	})
+	mux.HandleFunc("/singlesearch/shows/down", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	mux.HandleFunc("/singlesearch/shows/unknown", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("TVMAZE_URL_TEMPLATE", ts.URL+"/singlesearch/shows/%s")
+
+	client := TvMazeClient{logger: log.WithField("test", t.Name())}
+
+	found, err := client.Find("flaky")
+	require.NoError(t, err)
+	assert.Equal(t, "flaky", found.Name)
+	assert.Equal(t, 3, requests)
+
+	found, err = client.Find("unknown")
+	assert.NoError(t, err, "no match isn't an error")
+	assert.Nil(t, found)
+
+	_, err = client.Find("down")
+	assert.Error(t, err)
+
+	wait := backoff(2)
+	assert.True(t, wait >= 2*tvmazeBackoff && wait <= 4*tvmazeBackoff, wait)
+
+	assert.Equal(t, 5*time.Second, retryAfter("5", wait))
+	assert.Equal(t, tvmazeMaxBackoff, retryAfter("86400", wait))
+	assert.Equal(t, tvmazeMaxBackoff, retryAfter("99999999999999999", wait))
+	assert.Equal(t, wait, retryAfter("Wed, 21 Oct 2015 07:28:00 GMT", wait))
+	assert.Equal(t, wait, retryAfter("", wait))
+}
+
+func TestFailedShowKeepsItsListing(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("TVMAZE_URL_TEMPLATE", ts.URL+"/%s")
+
+	show, err := resolveShow("show1")
+	assert.Nil(t, show)
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	outputDir = dir
+	defer func() { outputDir = "" }()
+	writeShowsJSON([]ShowInList{convertToShowInList(testShow)})
+	assert.Equal(t, map[string]ShowInList{"/show1": convertToShowInList(testShow)}, readShowsJSON())
+}
+
+func TestMatchCandidates(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search/shows", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Doctor Who", r.URL.Query().Get("q"))
+		fmt.Fprintln(w, `[
+			{"score": 17.5, "show": {"id": 210, "name": "Doctor Who", "premiered": "2005-03-26", "network": {"name": "BBC One"}}},
+			{"score": 12.1, "show": {"id": 766, "name": "Doctor Who", "premiered": "1963-11-23", "network": null, "webChannel": {"name": "BBC iPlayer"}}}
+		]`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("TVMAZE_SEARCH_URL_TEMPLATE", ts.URL+"/search/shows?q=%s")
+
+	candidates, err := matchCandidates("Doctor Who")
+	require.NoError(t, err)
+	assert.Equal(t, []matchCandidate{
+		{ID: 210, Name: "Doctor Who", Score: 17.5, Year: "2005", Network: "BBC One"},
+		{ID: 766, Name: "Doctor Who", Score: 12.1, Year: "1963", Network: "BBC iPlayer"},
+	}, candidates)
+}
+
+func TestReviewMatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pinsFile = filepath.Join(dir, "pins.json")
+	defer func() { pinsFile = ".showme-pins.json" }()
+	pinned = pins{}
+	defer func() { pinned = pins{} }()
+
+	reviews := []matchReview{
+		{Dir: "Doctor Who (2005)", Candidates: []matchCandidate{{ID: 210}, {ID: 766}}},
+		{Dir: "Skipped", Candidates: []matchCandidate{{ID: 1}}},
+		{Dir: "By ID", Candidates: []matchCandidate{}},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, reviewMatches(reviews, strings.NewReader("9\n1\ns\n#42\n"), &out))
+
+	assert.Contains(t, out.String(), "Don't understand '9'")
+	saved, err := loadPins(pinsFile)
+	require.NoError(t, err)
+	assert.Equal(t, pins{"Doctor Who (2005)": 210, "By ID": 42}, saved)
+}
+
+func TestWriteFileOnlyWhenChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "show.json")
+
+	written, err := writeFile(fileName, []byte("{}"))
+	require.NoError(t, err)
+	assert.True(t, written)
+
+	written, err = writeFile(fileName, []byte("{}"))
+	require.NoError(t, err)
+	assert.False(t, written)
+
+	written, err = writeFile(fileName, []byte("[]"))
+	require.NoError(t, err)
+	assert.True(t, written)
+}
+
+func TestWriteFileIsAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fsyncWrites = true
+	defer func() { fsyncWrites = false }()
+
+	fileName := filepath.Join(dir, "shows.json")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("[]"), 0644))
+
+	// Readers holding the old file keep reading the old content.
+	old, err := os.Open(fileName)
+	require.NoError(t, err)
+	defer old.Close()
+
+	_, err = writeFile(fileName, []byte(`[{"name": "show1"}]`))
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadAll(old)
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+
+	data, err = ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, `[{"name": "show1"}]`, string(data))
+
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "no temporary files should be left behind")
+
+	_, err = writeFile(filepath.Join(dir, "missing", "shows.json"), []byte("[]"))
+	assert.Error(t, err)
+}
+
+func TestNFOProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "1"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "tvshow.nfo"), []byte(`<tvshow>
+		<title>Home Videos</title>
+		<plot>Holidays</plot>
+	</tvshow>`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "poster.jpg"), []byte{}, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1", "S01E01-Beach.nfo"), []byte(`<episodedetails>
+		<title>Beach</title>
+		<season>1</season>
+		<episode>1</episode>
+	</episodedetails>`), 0644))
+
+	show, err := fetchShow(nfoProvider{logger: log.WithField("test", true)}, dir)
+	require.NoError(t, err)
+	require.NotNil(t, show)
+
+	assert.Equal(t, "Home Videos", show.Name)
+	assert.Equal(t, "Holidays", show.Summary)
+	assert.Equal(t, "/"+filepath.Join(dir, "poster.jpg"), show.Image.Medium)
+	require.Len(t, show.Episodes, 1)
+	assert.Equal(t, Episode{Season: 1, Number: 1, Name: "Beach"}, show.Episodes[0])
+
+	show, err = nfoProvider{}.SearchShow(filepath.Join(dir, "1"))
+	assert.NoError(t, err)
+	assert.Nil(t, show)
+}
+
+func TestNFOProviderIsNotCached(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	cache = loadCache("", time.Hour)
+	defer func() { cache = nil }()
+
+	writeNFO := func(title string) {
+		require.NoError(t, ioutil.WriteFile("show1/tvshow.nfo", []byte("<tvshow><title>"+title+"</title></tvshow>"), 0644))
+	}
+
+	writeNFO("Home Videos")
+	show := findMatchingShow("show1")
+	require.NotNil(t, show)
+	assert.Equal(t, "Home Videos", show.Name)
+	_, cached := cache.lookup("show1")
+	assert.False(t, cached, "offline answers aren't cached")
+
+	writeNFO("Holidays")
+	show = findMatchingShow("show1")
+	require.NotNil(t, show)
+	assert.Equal(t, "Holidays", show.Name)
+
+	// Cached by an earlier version.
+	cache.Shows["show1"] = cacheEntry{FetchedAt: time.Now(), Show: &Show{Name: "Home Videos"}, Provider: "nfo"}
+	show = findMatchingShow("show1")
+	require.NotNil(t, show)
+	assert.Equal(t, "Holidays", show.Name)
+
+	// Online answers still come from the cache, after the offline providers.
+	require.NoError(t, os.Remove("show1/tvshow.nfo"))
+	cache.store("show1", 0, testShow)
+	show = findMatchingShow("show1")
+	require.NotNil(t, show)
+	assert.Equal(t, "show1", show.Name)
+}
+
+func TestSidecarProviderIsNotCached(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	defer func(names string) { providerNames = names }(providerNames)
+	providerNames = "sidecar,tvmaze"
+
+	cache = loadCache("", time.Hour)
+	defer func() { cache = nil }()
+
+	require.NoError(t, ioutil.WriteFile("show1/show.yaml", []byte(`
+name: Home Videos
+episodes:
+  - season: 1
+    number: 1
+    name: Beach
+  - season: 1
+    number: 2
+    name: Mountains
+`), 0644))
+	show, err := resolveShow("show1")
+	require.NoError(t, err)
+	require.NotNil(t, show)
+	assert.Len(t, show.Episodes, 2)
+
+	require.NoError(t, ioutil.WriteFile("show1/show.yaml", []byte(`
+name: Home Videos
+episodes:
+  - season: 1
+    number: 1
+    name: Beach
+`), 0644))
+	show, err = resolveShow("show1")
+	require.NoError(t, err)
+	require.NotNil(t, show)
+	assert.Len(t, show.Episodes, 1)
+	_, cached := cache.lookup("show1")
+	assert.False(t, cached, "offline answers aren't cached")
+}
+
+func TestFindMatchingShowFromSidecars(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	require.NoError(t, ioutil.WriteFile("show1/show.yaml", []byte(`
+name: Home Videos
+offline: true
+image:
+  medium: poster.jpg
+episodes:
+  - season: 1
+    number: 1
+    name: Beach
+`), 0644))
+	require.NoError(t, ioutil.WriteFile("show1/1/S01E02.yaml", []byte(`
+name: Mountains
+summary: Walking
+`), 0644))
+
+	show := findMatchingShow("show1")
+	require.NotNil(t, show)
+
+	assert.Equal(t, "sidecar", show.provider)
+	assert.Equal(t, "Home Videos", show.Name)
+	assert.Equal(t, "poster.jpg", show.Image.Medium)
+	assert.Equal(t, []Episode{
+		{Season: 1, Number: 1, Name: "Beach"},
+		{Season: 1, Number: 2, Name: "Mountains", Summary: "Walking"},
+	}, show.Episodes)
+}
+
+func TestSidecarDoesNotHideLookupErrors(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	require.NoError(t, ioutil.WriteFile("show1/show.yaml", []byte("summary: Ours\n"), 0644))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("TVMAZE_URL_TEMPLATE", ts.URL+"/%s")
+	defer os.Setenv("TVMAZE_URL_TEMPLATE", "http://127.0.0.1:0/%s")
+
+	show, err := resolveShow("show1")
+	assert.Error(t, err)
+	assert.Nil(t, show)
+
+	cache = loadCache("", time.Hour)
+	defer func() { cache = nil }()
+	cache.Shows["show1"] = cacheEntry{
+		FetchedAt: time.Now().Add(-2 * time.Hour),
+		Show:      &testShow.Show,
+	}
+
+	show, err = resolveShow("show1")
+	require.NoError(t, err)
+	require.NotNil(t, show)
+	assert.True(t, show.stale)
+	assert.Len(t, show.Episodes, 5)
+	assert.Equal(t, "Ours", show.Summary)
+}
+
+func TestSidecarOverrides(t *testing.T) {
+	show := &Show{
+		Name:    "Doctor Who",
+		Summary: "A time traveller",
+		Episodes: []Episode{
+			{Season: 1, Number: 1, Name: "Rose"},
+		},
+	}
+
+	sidecar := &sidecar{Name: "Doctor Who (2005)"}
+	sidecar.Episodes = []sidecarEpisode{{Season: 1, Number: 1, Summary: "Meet Rose"}}
+	sidecar.apply(show)
+
+	assert.Equal(t, "Doctor Who (2005)", show.Name)
+	assert.Equal(t, "A time traveller", show.Summary)
+	assert.Equal(t, []Episode{{Season: 1, Number: 1, Name: "Rose", Summary: "Meet Rose"}}, show.Episodes)
+}
+
 func TestGoodEnoughMatch(t *testing.T) {
 	table := []struct {
 		s1             string
@@ -94,13 +563,10 @@ func TestGoodEnoughMatch(t *testing.T) {
 func TestConvertToShowInList(t *testing.T) {
 	show := &show{
 		path: "foo",
-		TvMazeShow: TvMazeShow{
+		Show: Show{
 			Name:    "foo",
 			Summary: "bar",
-			Image: struct {
-				Medium   string `json:"medium"`
-				Original string `json:"original"`
-			}{
+			Image: Image{
 				Medium:   "baz",
 				Original: "buzz",
 			},
@@ -179,7 +645,7 @@ func TestCreateShowJSON(t *testing.T) {
 	require.NoError(t, os.Chdir("testdata/Videos"))
 	defer os.Chdir("../..")
 
-	require.NoError(t, writeShowJSON(tvMazeShow))
+	require.NoError(t, writeShowJSON(testShow))
 
 	file, err := os.Open("show1/show.json")
 	require.NoError(t, err)
@@ -235,7 +701,7 @@ func TestCreateSeasonJSON(t *testing.T) {
 	require.NoError(t, os.Chdir("testdata/Videos"))
 	defer os.Chdir("../..")
 
-	writeSeasons(tvMazeShow)
+	writeSeasons(testShow)
 
 	file, err := os.Open("show1/1/season.json")
 	require.NoError(t, err)
@@ -243,10 +709,10 @@ func TestCreateSeasonJSON(t *testing.T) {
 	season := &Season{}
 	require.NoError(t, json.NewDecoder(file).Decode(season))
 
-	assert.Equal(t, tvMazeShow.Name, season.Name)
-	assert.Equal(t, tvMazeShow.Summary, season.Summary)
-	assert.Equal(t, tvMazeShow.Image, season.Image)
-	assert.Equal(t, int(tvMazeShow.Embedded.Episodes[0].Season), season.Number)
+	assert.Equal(t, testShow.Name, season.Name)
+	assert.Equal(t, testShow.Summary, season.Summary)
+	assert.Equal(t, testShow.Image, season.Image)
+	assert.Equal(t, testShow.Episodes[0].Season, season.Number)
 	require.Len(t, season.Episodes, 2)
 	assert.Equal(t, "/show1/1/first", season.Episodes[0].URL)
 	assert.Equal(t, "/show1/1/second", season.Episodes[1].URL)
@@ -278,7 +744,7 @@ func TestCreateEpisodeJSON(t *testing.T) {
 	require.NoError(t, os.Chdir("testdata/Videos"))
 	defer os.Chdir("../..")
 
-	writeEpisodes(tvMazeShow)
+	writeEpisodes(testShow)
 
 	file, err := os.Open("show1/1/first/episode.json")
 	require.NoError(t, err)
@@ -286,13 +752,887 @@ func TestCreateEpisodeJSON(t *testing.T) {
 	episode := &SingleEpisode{}
 	require.NoError(t, json.NewDecoder(file).Decode(episode))
 
-	assert.Equal(t, tvMazeShow.Name, episode.ShowName)
-	assert.Equal(t, int(tvMazeShow.Embedded.Episodes[0].Season), episode.SeasonNumber)
-	assert.Equal(t, tvMazeShow.Embedded.Episodes[0].Name, episode.Name)
+	assert.Equal(t, testShow.Name, episode.ShowName)
+	assert.Equal(t, testShow.Episodes[0].Season, episode.SeasonNumber)
+	assert.Equal(t, testShow.Episodes[0].Name, episode.Name)
 	assert.Equal(t, "/show1/1/S01E01_bar.webm", episode.VideoURL)
+	assert.Equal(t, "video/webm", episode.VideoType)
+}
+
+func TestOutputDir(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	output, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(output)
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	outputDir, mediaURL = output, "/media/"
+	defer func() { outputDir, mediaURL = "", "" }()
+
+	writeShow(testShow)
+	writeSeasons(testShow)
+	writeEpisodes(testShow)
+	writeShows([]ShowInList{convertToShowInList(testShow)})
+
+	for _, name := range []string{"shows.json", "show1/show.json", "show1/1/season.json", "show1/1/first/episode.json", "show1/1/first/index.html"} {
+		_, err := os.Stat(filepath.Join(output, name))
+		assert.NoError(t, err, name)
+	}
+	for _, name := range []string{"shows.json", "show1/show.json", "show1/1/season.json", "show1/1/first"} {
+		_, err := os.Stat(name)
+		assert.True(t, os.IsNotExist(err), "%s written to the media path", name)
+	}
+
+	file, err := os.Open(filepath.Join(output, "show1/1/first/episode.json"))
+	require.NoError(t, err)
+	defer file.Close()
+	episode := &SingleEpisode{}
+	require.NoError(t, json.NewDecoder(file).Decode(episode))
+	assert.Equal(t, "/media/show1/1/S01E01_bar.webm", episode.VideoURL)
+}
+
+func TestPendingChanges(t *testing.T) {
+	changes := pendingChanges{}
+	changes.add("show1/Season 02/S02E01.webm")
+	changes.add("show1/S01E03.webm")
+	changes.add("show1/show.yaml")
+	changes.add("show2/1/index.html")
+	changes.add("show2/1/season.json")
+	changes.add(".showme-cache.json")
+	changes.add("show3/.tvmaze")
+	changes.add("show4/Daily.2016.03.14.webm")
+	changes.add("_theme/style.css")
+
+	assert.Equal(t, pendingChanges{
+		"show1": {2: true, 1: true, allSeasons: true},
+		"show3": {allSeasons: true},
+		"show4": {allSeasons: true},
+	}, changes)
+}
+
+func TestApplyChanges(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	cache = loadCache("", time.Hour)
+	defer func() { cache = nil }()
+	cache.store("show1", 0, testShow)
+
+	library := newWatchedLibrary()
+	library.shows["show1"] = testShow
+	library.kept["show9"] = ShowInList{Name: "show9", URL: documentRoot + "show9"}
+	writeShow(testShow)
+	writeSeasons(testShow)
+	writeEpisodes(testShow)
+	require.NoError(t, ioutil.WriteFile("show1/1/notes", []byte{}, 0644))
+
+	require.NoError(t, os.Remove("show1/1/S01E02_foo.webm"))
+	changes := pendingChanges{}
+	changes.add("show1/1/S01E02_foo.webm")
+	applyChanges(library, changes)
+
+	_, err := os.Stat("show1/1/second")
+	assert.True(t, os.IsNotExist(err), "stale episode directory should be removed")
+	_, err = os.Stat("show1/1/first/episode.json")
+	assert.NoError(t, err)
+	_, err = os.Stat("show1/1/notes")
+	assert.NoError(t, err)
+
+	readShows := func() []ShowInList {
+		shows := []ShowInList{}
+		data, err := ioutil.ReadFile("shows.json")
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &shows))
+		return shows
+	}
+	shows := readShows()
+	require.Len(t, shows, 2)
+	assert.Equal(t, "show1", shows[0].Name)
+	assert.Equal(t, "show9", shows[1].Name, "shows kept after a failed lookup stay listed")
+
+	// TVMaze is down and nothing is cached, the show stays as it was.
+	cache = loadCache("", time.Hour)
+	os.Setenv("TVMAZE_URL_TEMPLATE", "http://127.0.0.1:0/%s")
+	changes = pendingChanges{}
+	changes.add("show1/1/S01E01_bar.webm")
+	applyChanges(library, changes)
+	assert.Equal(t, testShow, library.shows["show1"])
+	assert.Len(t, readShows(), 2)
+
+	require.NoError(t, os.RemoveAll("show1"))
+	changes = pendingChanges{}
+	changes.add("show1")
+	applyChanges(library, changes)
+
+	assert.Empty(t, library.shows)
+	assert.Equal(t, []ShowInList{{Name: "show9", URL: documentRoot + "show9"}}, readShows())
+}
+
+func TestPrune(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	cache = loadCache("", time.Hour)
+	defer func() { cache = nil }()
+	cache.store("show1", 0, testShow)
+
+	writeShow(testShow)
+	writeSeasons(testShow)
+	writeEpisodes(testShow)
+	require.NoError(t, ioutil.WriteFile("show1/1/S01E02_foo.vtt", []byte{}, 0644))
+	require.NoError(t, ioutil.WriteFile("show1/1/first/still-0123456789abcdef.jpg", []byte{}, 0644))
+	require.NoError(t, ioutil.WriteFile("show1/1/second/still-0123456789abcdef.jpg", []byte{}, 0644))
+	require.NoError(t, os.Remove("show1/1/S01E02_foo.webm"))
+	scans.reset()
+
+	stale, err := staleFiles()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"show1/1/second/episode.json",
+		"show1/1/second/index.html",
+		"show1/1/second/still-0123456789abcdef.jpg",
+		"show1/1/second",
+	}, stale)
+
+	var out bytes.Buffer
+	removeStale(stale, true, &out)
+	assert.Contains(t, out.String(), "would remove 'show1/1/second'")
+	_, err = os.Stat("show1/1/second/episode.json")
+	assert.NoError(t, err, "a dry run shouldn't remove anything")
+
+	removeStale(stale, false, &out)
+	_, err = os.Stat("show1/1/second")
+	assert.True(t, os.IsNotExist(err))
+	for _, name := range []string{"show1/1/S01E01_bar.webm", "show1/1/S01E02_foo.vtt", "show1/1/first/episode.json", "show1/1/first/still-0123456789abcdef.jpg", "show1/show.json"} {
+		_, err = os.Stat(name)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestPruneDryRunWritesNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	defer func() { cache = nil }()
+
+	runPrune([]string{"-dry-run", "."})
+	_, err = os.Stat(filepath.Join(dir, cacheFile))
+	assert.True(t, os.IsNotExist(err), "the cache is left alone")
+}
+
+func TestProcessShows(t *testing.T) {
+	workers = 3
+	defer func() { workers = 4 }()
+
+	dirs := []string{"a", "b", "bad", "c", "d", "e"}
+	results := processShows(dirs, func(dir string) (*show, error) {
+		if dir == "bad" {
+			panic("corrupt sidecar")
+		}
+		if dir == "a" {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return &show{path: dir}, nil
+	})
+
+	require.Len(t, results, len(dirs))
+	for i, result := range results {
+		assert.Equal(t, dirs[i], result.dir)
+		if result.dir == "bad" {
+			assert.Nil(t, result.show)
+			assert.EqualError(t, result.err, "corrupt sidecar")
+			continue
+		}
+		require.NoError(t, result.err)
+		assert.Equal(t, dirs[i], result.show.path)
+	}
+
+	report := newRunReport()
+	report.addFailure("bad", results[2].err)
+	var out bytes.Buffer
+	report.print(&out)
+	assert.Equal(t, "Failed (1):\n  bad: corrupt sidecar\n", out.String())
+}
+
+func TestDirScans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer scans.reset()
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "Season 01"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Season 01", "S01E01.webm"), []byte{}, 0644))
+
+	scans.reset()
+	scanned := scans.scan(filepath.Join(dir, "Season 01"))
+	require.Len(t, scanned.files, 1)
+	assert.Equal(t, parsedFilename{Season: 1, Episodes: []int{1}}, scanned.files[0].parsed)
+	assert.Equal(t, []string{"Season 01"}, scans.scan(dir).dirs)
+
+	// The run keeps using what it scanned until it's told otherwise.
+	require.NoError(t, os.Remove(filepath.Join(dir, "Season 01", "S01E01.webm")))
+	assert.Equal(t, []string{"S01E01.webm"}, episodeVideoFiles(filepath.Join(dir, "Season 01"), Episode{Season: 1, Number: 1}))
+
+	scans.reset()
+	assert.Empty(t, episodeVideoFiles(filepath.Join(dir, "Season 01"), Episode{Season: 1, Number: 1}))
+}
+
+func TestEpisodeVideoFilePreference(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"S01E01.mkv", "S01E01.mp4", "S01E01.srt", "S01E02.avi"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0644))
+	}
+
+	assert.Equal(t, "S01E01.mp4", episodeVideoFile(dir, Episode{Season: 1, Number: 1}))
+	assert.Equal(t, "", episodeVideoFile(dir, Episode{Season: 1, Number: 2}))
+
+	videoExtensions = "mkv,mp4"
+	defer func() { videoExtensions = "webm,mp4,m4v,ogv,mkv" }()
+	assert.Equal(t, "S01E01.mkv", episodeVideoFile(dir, Episode{Season: 1, Number: 1}))
+	assert.Equal(t, "video/x-matroska", videoType("S01E01.mkv"))
+}
+
+func TestSeasonDirPatternsCompiledOnce(t *testing.T) {
+	first, err := seasonDirPatterns()
+	require.NoError(t, err)
+	again, err := seasonDirPatterns()
+	require.NoError(t, err)
+	assert.True(t, &first[0] == &again[0], "patterns should be compiled once")
+
+	layouts := seasonDirLayouts
+	defer func() { seasonDirLayouts = layouts }()
+	seasonDirLayouts = `^Series (\d+)$`
+	changed, err := seasonDirPatterns()
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	number, ok := seasonDirNumber("Series 3", changed)
+	assert.True(t, ok)
+	assert.Equal(t, 3, number)
+
+	seasonDirLayouts = `(`
+	_, err = seasonDirPatterns()
+	assert.Error(t, err)
+}
+
+func TestAlternativeLayouts(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	require.NoError(t, os.MkdirAll("layouts/Season 03", 0755))
+	for _, name := range []string{"layouts/S01E01.webm", "layouts/Season 03/S03E01.webm"} {
+		require.NoError(t, ioutil.WriteFile(name, []byte{}, 0644))
+	}
+
+	layouts := &show{
+		path: "layouts",
+		Show: Show{
+			Name: "layouts",
+			Episodes: []Episode{
+				{Season: 1, Number: 1, Name: "flat"},
+				{Season: 2, Number: 1, Name: "absent"},
+				{Season: 3, Number: 1, Name: "nested"},
+			},
+		},
+	}
+
+	require.NoError(t, writeShowJSON(layouts))
+	writeSeasons(layouts)
+	writeEpisodes(layouts)
+
+	file, err := os.Open("layouts/show.json")
+	require.NoError(t, err)
+	show := &SingleShow{}
+	require.NoError(t, json.NewDecoder(file).Decode(show))
+	assert.Equal(t, []string{"/layouts/1", "/layouts/3"}, show.SeasonURLs)
+
+	file, err = os.Open("layouts/3/nested/episode.json")
+	require.NoError(t, err)
+	episode := &SingleEpisode{}
+	require.NoError(t, json.NewDecoder(file).Decode(episode))
+	assert.Equal(t, "/layouts/Season 03/S03E01.webm", episode.VideoURL)
+
+	file, err = os.Open("layouts/1/flat/episode.json")
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(file).Decode(episode))
+	assert.Equal(t, "/layouts/S01E01.webm", episode.VideoURL)
+}
+
+func TestAPI(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	cache = loadCache("", time.Hour)
+	defer func() { cache = nil }()
+	cache.store("show1", 0, testShow)
+
+	bin, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(bin)
+	grabs := fakeFFmpeg(t, bin)
+	defer useFFmpeg("ffmpeg")
+	localArtwork = true
+	defer func() { localArtwork = false }()
+
+	index := newLibraryIndex()
+	require.NoError(t, index.build())
+	for _, name := range []string{"show1/show.json", "show1/1/first", artworkDir} {
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err), "the API shouldn't write %s to the media root", name)
+	}
+	assert.Equal(t, 0, grabs())
+
+	ts := httptest.NewServer(index)
+	defer ts.Close()
+
+	get := func(path string, v interface{}) int {
+		response, err := http.Get(ts.URL + path)
+		require.NoError(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(response.Body).Decode(v))
+		return response.StatusCode
+	}
+
+	shows := []ShowInList{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/shows", &shows))
+	require.Len(t, shows, 1)
+	assert.Equal(t, "/api/v1/shows/show1", shows[0].URL)
+
+	show := SingleShow{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/shows/show1", &show))
+	assert.Equal(t, []string{"/api/v1/shows/show1/seasons/1", "/api/v1/shows/show1/seasons/2"}, show.SeasonURLs)
+
+	season := Season{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/shows/show1/seasons/1", &season))
+	require.Len(t, season.Episodes, 2)
+	assert.Equal(t, "/api/v1/shows/show1/seasons/1/episodes/2", season.Episodes[1].URL)
+
+	episode := SingleEpisode{}
+	assert.Equal(t, http.StatusOK, get("/api/v1/shows/show1/seasons/1/episodes/2", &episode))
+	assert.Equal(t, "second", episode.Name)
+	assert.Equal(t, "/show1/1/S01E02_foo.webm", episode.VideoURL)
+
+	apiErr := apiError{}
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/shows/show1/seasons/1/episodes/3", &apiErr))
+	assert.Equal(t, "episode not found", apiErr.Error)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/shows/show2", &apiErr))
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/movies", &apiErr))
+}
+
+func TestLoadApp(t *testing.T) {
+	builtIn, err := loadApp("shows.html")()
+	require.NoError(t, err)
+	assert.Contains(t, string(builtIn), "shows.json")
+
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "show.html"), []byte("custom"), 0644))
+
+	themeDir = dir
+	defer func() { themeDir = "" }()
+
+	data, err := loadApp("show.html")()
+	require.NoError(t, err)
+	assert.Equal(t, "custom", string(data))
+
+	data, err = loadApp("shows.html")()
+	require.NoError(t, err)
+	assert.Equal(t, builtIn, data, "apps missing from -theme are built in")
+
+	themeDir = filepath.Join(dir, "missing")
+	_, err = loadApp("shows.html")()
+	assert.Error(t, err)
+
+	themeDir = filepath.Join(dir, "show.html")
+	_, err = loadApp("shows.html")()
+	assert.EqualError(t, err, "-theme: "+themeDir+" is not a directory")
+}
+
+func TestRenderedApps(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	writeShow(testShow)
+	writeSeasons(testShow)
+	writeEpisodes(testShow)
+	writeShows([]ShowInList{convertToShowInList(testShow)})
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Contains(t, read("index.html"), "<a href='"+documentRoot+"show1'><div class='missing'></div><span>show1</span></a>")
+	assert.Contains(t, read("show1/index.html"), "<a href='"+documentRoot+"show1/1'><div class='missing'></div><span>Season 1</span></a>")
+	season := read("show1/1/index.html")
+	assert.Contains(t, season, "<a href='"+documentRoot+"show1/1/first'><div class='missing'></div><span>1-first</span></a>")
+	assert.NotContains(t, season, "third")
+	episode := read("show1/1/first/index.html")
+	assert.Contains(t, episode, "<div id='title'>show1 - first</div>")
+	assert.Contains(t, episode, "<source src='"+documentRoot+"show1/1/S01E01_bar.webm' type='video/webm'>")
+	assert.Contains(t, episode, "src='"+documentRoot+"show1/1/S01E01_bar.en.vtt'")
+	assert.Contains(t, episode, "fetch('episode.json'", "the JSON still enhances the page")
+
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "shows.html"), []byte("{{range .}}<p>{{.Name}}</p>{{end}}"), 0644))
+
+	themeDir = dir
+	defer func() {
+		themeDir = ""
+		showsApp = builtInApp("shows.html")
+	}()
+	require.NoError(t, loadShowsApp())
+	writeShows([]ShowInList{{Name: "<b>show1</b>"}})
+	assert.Equal(t, "<p>&lt;b&gt;show1&lt;/b&gt;</p>", read("index.html"))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "shows.html"), []byte("{{range .}"), 0644))
+	assert.Error(t, loadShowsApp())
+}
+
+func TestTheme(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	theme, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(theme)
+	require.NoError(t, os.MkdirAll(filepath.Join(theme, "assets/fonts"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(theme, "assets/style.css"), []byte("body {}"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(theme, "assets/fonts/big.woff"), []byte("font"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(theme, "assets/.hidden"), []byte{}, 0644))
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	require.NoError(t, writeThemeAssets())
+	builtIn, err := ioutil.ReadFile("_theme/style.css")
+	require.NoError(t, err)
+	assert.Contains(t, string(builtIn), ".posters")
+	builtIn, err = ioutil.ReadFile("_theme/posters.js")
+	require.NoError(t, err)
+	assert.Contains(t, string(builtIn), "function createPoster")
+
+	themeDir = theme
+	defer func() { themeDir = "" }()
+
+	require.NoError(t, writeThemeAssets())
+	for name, content := range map[string]string{"_theme/style.css": "body {}", "_theme/fonts/big.woff": "font"} {
+		data, err := ioutil.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+	_, err = os.Stat("_theme/.hidden")
+	assert.True(t, os.IsNotExist(err))
+
+	writeShows([]ShowInList{{
+		Name:  "show1",
+		Image: Image{Medium: "http://example.com/show1.jpg"},
+		URL:   documentRoot + "show1",
+	}})
+	data, err := ioutil.ReadFile("index.html")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "href='"+documentRoot+"_theme/style.css'")
+	assert.Contains(t, string(data), "<script src='"+documentRoot+"_theme/posters.js'></script>")
+	assert.Contains(t, string(data), "<img src='http://example.com/show1.jpg' alt=''><span>show1</span>")
+
+	themeDir = filepath.Join(theme, "assets/style.css")
+	assert.Error(t, writeThemeAssets())
+}
+
+func TestArtwork(t *testing.T) {
+	poster := image.NewRGBA(image.Rect(0, 0, 420, 600))
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 420; x++ {
+			poster.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, poster))
+
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/poster.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(encoded.Bytes())
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	output, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(output)
+
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	outputDir, localArtwork = output, true
+	defer func() {
+		outputDir, localArtwork = "", false
+		artwork = newArtworkStore()
+	}()
+
+	remote := Image{Medium: ts.URL + "/medium.png", Original: ts.URL + "/poster.png"}
+	local := localImage(remote)
+	assert.Equal(t, documentRoot+artworkFile(ts.URL+"/poster.png"), local.Original)
+	assert.True(t, strings.HasSuffix(local.Medium, "-210w.jpg"), local.Medium)
+
+	file, err := os.Open(filepath.Join(output, strings.TrimPrefix(local.Medium, documentRoot)))
+	require.NoError(t, err)
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	require.NoError(t, err)
+	assert.Equal(t, 210, config.Width)
+	assert.Equal(t, 300, config.Height)
+
+	absent := Image{Medium: ts.URL + "/absent.png"}
+	shown := &show{path: "show1", Show: Show{Image: remote, Episodes: []Episode{
+		{Name: "first", Season: 1, Number: 1, Image: remote},
+		{Name: "third", Season: 1, Number: 3, Image: absent},
+	}}}
+	localizeShowArtwork(shown)
+	assert.Equal(t, local, shown.Image)
+	assert.Equal(t, local, shown.Episodes[0].Image)
+	assert.Equal(t, absent, shown.Episodes[1].Image, "episodes that aren't on disk are left alone")
+	assert.Equal(t, 1, requests, "the same artwork is downloaded once")
+
+	// A next run finds the artwork in the output.
+	artwork = newArtworkStore()
+	assert.Equal(t, local, localImage(remote))
+	assert.Equal(t, 1, requests)
+
+	missing := Image{Medium: ts.URL + "/missing.jpg"}
+	assert.Equal(t, missing, localImage(missing), "failed downloads link to the provider")
+	assert.Equal(t, 2, requests)
+	sidecar := Image{Medium: "/posters/show1.jpg"}
+	assert.Equal(t, sidecar, localImage(sidecar))
+}
+
+// fakeFFmpeg puts an ffmpeg in bin that writes a frame to its last argument
+// and uses it. It returns how often it ran.
+func fakeFFmpeg(t *testing.T, bin string) func() int {
+	script := "#!/bin/sh\nfor last; do :; done\necho frame > \"$last\"\necho >> " + filepath.Join(bin, "grabs") + "\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0755))
+	useFFmpeg(filepath.Join(bin, "ffmpeg"))
+
+	return func() int {
+		data, _ := ioutil.ReadFile(filepath.Join(bin, "grabs"))
+		return len(data)
+	}
+}
+
+func useFFmpeg(binary string) {
+	ffmpegBinary, ffmpegPath, ffmpegOnce = binary, "", sync.Once{}
+}
+
+func TestEpisodeStill(t *testing.T) {
+	copyR("testdata/Videos_template", "testdata/Videos")
+	defer os.RemoveAll("testdata/Videos")
+
+	bin, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(bin)
+	grabs := fakeFFmpeg(t, bin)
+	defer useFFmpeg("ffmpeg")
+
+	require.NoError(t, os.Chdir("testdata/Videos"))
+	defer os.Chdir("../..")
+
+	localize := func() *show {
+		shown := *testShow
+		shown.Episodes = append([]Episode{}, testShow.Episodes...)
+		shown.Episodes[1].Image = Image{Medium: "/second.jpg"}
+		localizeShowArtwork(&shown)
+		return &shown
+	}
+
+	shown := localize()
+	first := shown.Episodes[0].Image
+	assert.True(t, strings.HasPrefix(first.Medium, documentRoot+"show1/1/first/still-"), first.Medium)
+	assert.Equal(t, first.Medium, first.Original)
+	data, err := ioutil.ReadFile(strings.TrimPrefix(first.Medium, documentRoot))
+	require.NoError(t, err)
+	assert.Equal(t, "frame\n", string(data))
+	assert.Equal(t, Image{Medium: "/second.jpg"}, shown.Episodes[1].Image, "artwork of the provider wins")
+	assert.Equal(t, Image{}, shown.Episodes[2].Image, "no video, no still")
+	assert.Equal(t, 1, grabs())
+
+	assert.Equal(t, first, localize().Episodes[0].Image)
+	assert.Equal(t, 1, grabs(), "the video didn't change")
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes("show1/1/S01E01_bar.webm", later, later))
+	changed := localize().Episodes[0].Image
+	assert.NotEqual(t, first, changed)
+	assert.Equal(t, 2, grabs())
+	_, err = os.Stat(strings.TrimPrefix(first.Medium, documentRoot))
+	assert.True(t, os.IsNotExist(err), "the old still is removed")
+
+	useFFmpeg("")
+	require.NoError(t, os.Chtimes("show1/1/S01E01_bar.webm", time.Now(), time.Now()))
+	assert.Equal(t, Image{}, localize().Episodes[0].Image)
+}
+
+func TestMovieTitle(t *testing.T) {
+	table := []struct {
+		name  string
+		title string
+		year  int
+	}{
+		{"Big Buck Bunny (2008)", "Big Buck Bunny", 2008},
+		{"Big.Buck.Bunny.2008.1080p.mp4", "Big Buck Bunny", 2008},
+		{"Blade Runner 2049 (2017).mkv", "Blade Runner 2049", 2017},
+		{"2001 A Space Odyssey", "2001 A Space Odyssey", 0},
+		{"Sintel.webm", "Sintel", 0},
+	}
+
+	for _, v := range table {
+		title, year := movieTitle(v.name)
+		assert.Equal(t, v.title, title, v.name)
+		assert.Equal(t, v.year, year, v.name)
+	}
+}
+
+func TestMovies(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/omdb/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.URL.Query().Get("apikey"))
+		if r.URL.Query().Get("t") != "Big Buck Bunny" {
+			fmt.Fprintln(w, `{"Response": "False", "Error": "Movie not found!"}`)
+			return
+		}
+		assert.Equal(t, "2008", r.URL.Query().Get("y"))
+		fmt.Fprintln(w, `{"Title": "Big Buck Bunny", "Year": "2008", "Plot": "A rabbit", "Poster": "N/A", "imdbID": "tt1254207", "Response": "True"}`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("OMDB_URL", ts.URL+"/omdb/")
+	os.Setenv("OMDB_API_KEY", "secret")
+	defer os.Unsetenv("OMDB_API_KEY")
+
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	require.NoError(t, os.Mkdir("Big Buck Bunny (2008)", 0755))
+	for _, name := range []string{"Big Buck Bunny (2008)/bunny.720p.mp4", "Sintel.webm", "Unknown.mp4", "notes.txt"} {
+		require.NoError(t, ioutil.WriteFile(name, []byte{}, 0644))
+	}
+	require.NoError(t, ioutil.WriteFile("Sintel.yaml", []byte("offline: true\nsummary: A dragon\n"), 0644))
+
+	bunny, err := findMatchingMovie("Big Buck Bunny (2008)", true)
+	require.NoError(t, err)
+	require.NotNil(t, bunny)
+	assert.Equal(t, "omdb", bunny.provider)
+	assert.Equal(t, Movie{ID: "tt1254207", Name: "Big Buck Bunny", Year: 2008, Summary: "A rabbit"}, bunny.Movie)
+
+	sintel, err := findMatchingMovie("Sintel.webm", false)
+	require.NoError(t, err)
+	require.NotNil(t, sintel)
+	assert.Equal(t, "sidecar", sintel.provider)
+	assert.Equal(t, "A dragon", sintel.Summary)
+
+	for _, name := range []string{"Unknown.mp4", "notes.txt"} {
+		found, err := findMatchingMovie(name, false)
+		assert.NoError(t, err)
+		assert.Nil(t, found, name)
+	}
+
+	writeMovie(sintel)
+	file, err := os.Open("Sintel/movie.json")
+	require.NoError(t, err)
+	movie := &SingleMovie{}
+	require.NoError(t, json.NewDecoder(file).Decode(movie))
+	assert.Equal(t, "/Sintel.webm", movie.VideoURL)
+	assert.Equal(t, "/Sintel", convertToMovieInList(sintel).URL)
+}
+
+func TestMovieLookupFailures(t *testing.T) {
+	limited := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/omdb/", func(w http.ResponseWriter, r *http.Request) {
+		if limited {
+			fmt.Fprintln(w, `{"Response": "False", "Error": "Request limit reached!"}`)
+			return
+		}
+		fmt.Fprintln(w, `{"Title": "Big Buck Bunny", "Year": "2008", "Plot": "A rabbit", "imdbID": "tt1254207", "Response": "True"}`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	os.Setenv("OMDB_URL", ts.URL+"/omdb/")
+	defer os.Unsetenv("OMDB_API_KEY")
+
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	require.NoError(t, ioutil.WriteFile("Big Buck Bunny (2008).mp4", []byte{}, 0644))
+
+	cache = loadCache("", time.Hour)
+	defer func() { cache = nil }()
+
+	// Without an API key OMDb isn't asked, so nothing is cached.
+	os.Unsetenv("OMDB_API_KEY")
+	found, err := findMatchingMovie("Big Buck Bunny (2008).mp4", false)
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+	assert.Empty(t, cache.Movies)
+
+	// Nor when OMDb refuses to answer.
+	os.Setenv("OMDB_API_KEY", "secret")
+	limited = true
+	found, err = findMatchingMovie("Big Buck Bunny (2008).mp4", false)
+	assert.Error(t, err)
+	assert.Nil(t, found)
+	assert.Empty(t, cache.Movies)
+
+	// A film listed by an earlier run stays listed when its lookup fails.
+	defer func(file string) { cacheFile = file }(cacheFile)
+	cacheFile = ""
+	cache = nil
+	readMovies := func() []MovieInList {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "movies.json"))
+		require.NoError(t, err)
+		movies := []MovieInList{}
+		require.NoError(t, json.Unmarshal(data, &movies))
+		return movies
+	}
+
+	limited = false
+	runMovies([]string{"."})
+	require.NoError(t, os.Chdir(dir))
+	listed := readMovies()
+	require.Len(t, listed, 1)
+
+	limited = true
+	runMovies([]string{"."})
+	require.NoError(t, os.Chdir(dir))
+	assert.Equal(t, listed, readMovies())
+}
+
+func TestParseFilename(t *testing.T) {
+	table := []struct {
+		name     string
+		expected parsedFilename
+		ok       bool
+	}{
+		{"S01E01_bar.webm", parsedFilename{Season: 1, Episodes: []int{1}}, true},
+		{"Name s1e2 Title.mp4", parsedFilename{Season: 1, Episodes: []int{2}}, true},
+		{"Name.S01E100.mkv", parsedFilename{Season: 1, Episodes: []int{100}}, true},
+		{"Name - 1x02 - Title.mp4", parsedFilename{Season: 1, Episodes: []int{2}}, true},
+		{"Name.1x02x03.mp4", parsedFilename{Season: 1, Episodes: []int{2, 3}}, true},
+		{"Name.S01E01E02.webm", parsedFilename{Season: 1, Episodes: []int{1, 2}}, true},
+		{"Name.S01E01-E03.webm", parsedFilename{Season: 1, Episodes: []int{1, 2, 3}}, true},
+		{"S01E01-S01E02.mkv", parsedFilename{Season: 1, Episodes: []int{1, 2}}, true},
+		{"Name.s02e03-s02e05.mkv", parsedFilename{Season: 2, Episodes: []int{3, 4, 5}}, true},
+		{"Name.S02E05-06.webm", parsedFilename{Season: 2, Episodes: []int{5, 6}}, true},
+		{"Name.S01E01-720p.webm", parsedFilename{Season: 1, Episodes: []int{1}}, true},
+		{"Daily Show 2016.03.14 Guest.mp4", parsedFilename{AirDate: "2016-03-14"}, true},
+		{"Daily_Show_2016-03-14.mp4", parsedFilename{AirDate: "2016-03-14"}, true},
+		{"Movie.1920x1080.mp4", parsedFilename{}, false},
+		{"Daily Show 2016.13.14.mp4", parsedFilename{}, false},
+		{"aap", parsedFilename{}, false},
+	}
+
+	for _, v := range table {
+		parsed, ok := parseFilename(v.name)
+		assert.Equal(t, v.ok, ok, v.name)
+		assert.Equal(t, v.expected, parsed, v.name)
+	}
+
+	assert.True(t, parsedFilename{AirDate: "2016-03-14"}.contains(Episode{Season: 21, Number: 80, AirDate: "2016-03-14"}))
+	assert.False(t, parsedFilename{Season: 1, Episodes: []int{1, 2}}.contains(Episode{Season: 2, Number: 1}))
+}
+
+func TestUnparseableFilesAreReported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"S01E01-E02.webm", "holiday.webm", "notes.txt"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0644))
+	}
+
+	report = newRunReport()
+	defer func() { report = nil }()
+
+	assert.Equal(t, "S01E01-E02.webm", episodeVideoFile(dir, Episode{Season: 1, Number: 2}))
+
+	var out bytes.Buffer
+	report.print(&out)
+	assert.Equal(t, "Unparseable file names (1):\n  "+filepath.Join(dir, "holiday.webm")+"\n", out.String())
+}
+
+func TestVideoSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"S01E01.480p.mp4", "S01E01.webm", "S01E01.1080p.mp4"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte{}, 0644))
+	}
+
+	sources := videoSources(seasonVideoFiles([]string{dir}, Episode{Season: 1, Number: 1}))
+	require.Len(t, sources, 3)
+
+	assert.Equal(t, "/"+filepath.Join(dir, "S01E01.webm"), sources[0].URL)
+	assert.Equal(t, "webm", sources[0].Container)
+	assert.Equal(t, "/"+filepath.Join(dir, "S01E01.1080p.mp4"), sources[1].URL)
+	assert.Equal(t, "1080p", sources[1].Resolution)
+	assert.Equal(t, "video/mp4", sources[1].Type)
+	assert.Equal(t, 480, sources[2].Height)
 }
 
 func copyR(src, dest string) {
+	// Every test starts with a fresh media path.
+	scans.reset()
+
 	filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		target := strings.Replace(path, src, dest, -1)
 		if info.IsDir() {
@@ -330,3 +1670,68 @@ func copyFile(source string, dest string) (err error) {
 
 	return
 }
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "showme")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Show", "1"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Show", "1", "S01E01.webm"), []byte("0123456789"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Show", "index.html"), []byte("<html></html>"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Pioneer One"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "shows.json"), []byte(`[{"name": "Show"}]`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".showme-pins.json"), []byte(`{}`), 0644))
+
+	mux := http.NewServeMux()
+	mux.Handle("/shows/", http.StripPrefix("/shows", mediaHandler{roots: []string{dir}}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(path string, header map[string]string) *http.Response {
+		request, err := http.NewRequest("GET", ts.URL+path, nil)
+		require.NoError(t, err)
+		for key, value := range header {
+			request.Header.Set(key, value)
+		}
+		response, err := http.DefaultTransport.RoundTrip(request)
+		require.NoError(t, err)
+		return response
+	}
+
+	response := get("/shows/Show/1/S01E01.webm", map[string]string{"Range": "bytes=2-4"})
+	body, _ := ioutil.ReadAll(response.Body)
+	assert.Equal(t, http.StatusPartialContent, response.StatusCode)
+	assert.Equal(t, "video/webm", response.Header.Get("Content-Type"))
+	assert.Equal(t, "234", string(body))
+	etag := response.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, response.Header.Get("Last-Modified"))
+
+	response = get("/shows/Show/1/S01E01.webm", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, response.StatusCode)
+
+	response = get("/shows/shows.json", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+	assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+	reader, err := gzip.NewReader(response.Body)
+	require.NoError(t, err)
+	body, _ = ioutil.ReadAll(reader)
+	assert.Equal(t, `[{"name": "Show"}]`, string(body))
+
+	response = get("/shows/Show", nil)
+	assert.Equal(t, http.StatusMovedPermanently, response.StatusCode)
+	assert.Equal(t, "/shows/Show/", response.Header.Get("Location"))
+	response = get("/shows/Pioneer%20One?autoplay=1", nil)
+	assert.Equal(t, http.StatusMovedPermanently, response.StatusCode)
+	assert.Equal(t, "/shows/Pioneer%20One/?autoplay=1", response.Header.Get("Location"))
+	response = get("/shows/Show/", nil)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header.Get("Content-Type"))
+
+	response = get("/shows/.showme-pins.json", nil)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	response = get("/shows/Show/1/", nil)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
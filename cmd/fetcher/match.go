@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// matchCandidate is a show TVMaze considers a possible match for a directory.
+type matchCandidate struct {
+	ID      int64   `json:"id"`
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"`
+	Year    string  `json:"year"`
+	Network string  `json:"network"`
+}
+
+type matchReview struct {
+	Dir        string           `json:"dir"`
+	Candidates []matchCandidate `json:"candidates"`
+}
+
+// runMatch is the 'match' subcommand. It lists TVMaze candidates for every
+// show directory that doesn't match and lets the operator pin one.
+func runMatch(args []string) {
+	flags := flag.NewFlagSet("match", flag.ExitOnError)
+	report := flags.Bool("report", false, "Print the candidates as JSON instead of asking.")
+	all := flags.Bool("all", false, "Also review directories that already match.")
+	flags.Parse(args)
+
+	enterMediaRoot(flags.Args())
+
+	dirs, err := unmatchedDirs(*all)
+	if err != nil {
+		log.WithField("err", err).Fatal("Error reading media path")
+	}
+
+	reviews := []matchReview{}
+	for _, dir := range dirs {
+		candidates, err := matchCandidates(dir)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+				"dir": dir,
+			}).Error("Error searching TVMaze")
+			continue
+		}
+		reviews = append(reviews, matchReview{Dir: dir, Candidates: candidates})
+	}
+
+	if err := cache.save(); err != nil {
+		log.WithField("err", err).Error("Error saving cache")
+	}
+
+	if *report {
+		if err := json.NewEncoder(os.Stdout).Encode(reviews); err != nil {
+			log.WithField("err", err).Fatal("Error writing report")
+		}
+		return
+	}
+
+	if err := reviewMatches(reviews, os.Stdin, os.Stdout); err != nil {
+		log.WithField("err", err).Fatal("Error reviewing matches")
+	}
+}
+
+// unmatchedDirs returns the show directories that aren't pinned, aren't
+// described by an offline sidecar and, unless all is set, don't match.
+func unmatchedDirs(all bool) ([]string, error) {
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{}
+	for _, file := range files {
+		if !file.IsDir() || isGeneratedDir(file.Name()) {
+			continue
+		}
+
+		if _, isPinned, _ := pinned.lookup(file.Name()); isPinned {
+			continue
+		}
+		if sidecar, _ := readSidecar(file.Name()); sidecar != nil && sidecar.Offline {
+			continue
+		}
+		if !all && findMatchingShow(file.Name()) != nil {
+			continue
+		}
+
+		dirs = append(dirs, file.Name())
+	}
+
+	return dirs, nil
+}
+
+func matchCandidates(dir string) ([]matchCandidate, error) {
+	tvMaze := TvMazeClient{logger: log.WithField("file", dir)}
+
+	results, err := tvMaze.Search(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []matchCandidate{}
+	for _, result := range results {
+		candidate := matchCandidate{
+			ID:    result.Show.ID,
+			Name:  result.Show.Name,
+			Score: result.Score,
+		}
+		if len(result.Show.Premiered) >= 4 {
+			candidate.Year = result.Show.Premiered[:4]
+		}
+		if result.Show.Network != nil {
+			candidate.Network = result.Show.Network.Name
+		} else if result.Show.WebChannel != nil {
+			candidate.Network = result.Show.WebChannel.Name
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, nil
+}
+
+// reviewMatches asks, for every review, which candidate is the right show and
+// pins the directory to it.
+func reviewMatches(reviews []matchReview, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+
+	for _, review := range reviews {
+		fmt.Fprintf(out, "\n%s\n", review.Dir)
+		if len(review.Candidates) == 0 {
+			fmt.Fprintln(out, "  no candidates found")
+		}
+		for i, candidate := range review.Candidates {
+			fmt.Fprintf(out, "  %d) %s (%s, %s) score %.2f, id %d\n",
+				i+1, candidate.Name, orUnknown(candidate.Year), orUnknown(candidate.Network), candidate.Score, candidate.ID)
+		}
+
+		for {
+			fmt.Fprintf(out, "Pick 1-%d, a TVMaze ID as #id, s to skip or q to quit: ", len(review.Candidates))
+			if !scanner.Scan() {
+				return scanner.Err()
+			}
+
+			answer := strings.TrimSpace(scanner.Text())
+			if answer == "q" {
+				return nil
+			}
+			if answer == "s" || answer == "" {
+				break
+			}
+
+			id, ok := pickCandidate(answer, review.Candidates)
+			if !ok {
+				fmt.Fprintf(out, "Don't understand '%s'\n", answer)
+				continue
+			}
+
+			pinned[review.Dir] = id
+			if err := pinned.save(pinsFile); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Pinned %s to %d\n", review.Dir, id)
+			break
+		}
+	}
+
+	return nil
+}
+
+func pickCandidate(answer string, candidates []matchCandidate) (int64, bool) {
+	if strings.HasPrefix(answer, "#") {
+		id, err := strconv.ParseInt(answer[1:], 10, 64)
+		return id, err == nil
+	}
+
+	choice, err := strconv.Atoi(answer)
+	if err != nil || choice < 1 || choice > len(candidates) {
+		return 0, false
+	}
+	return candidates[choice-1].ID, true
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type Image struct {
+	Medium   string `json:"medium"`
+	Original string `json:"original"`
+}
+
+// Show is the provider independent description of a show. The writers only
+// ever see this, never a provider's own JSON shape.
+type Show struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Summary  string    `json:"summary"`
+	Image    Image     `json:"image"`
+	Episodes []Episode `json:"episodes"`
+}
+
+type Episode struct {
+	Season  int    `json:"season"`
+	Number  int    `json:"number"`
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+	Image   Image  `json:"image"`
+	AirDate string `json:"airdate"`
+}
+
+// MetadataProvider is a source of show information. SearchShow is handed the
+// name of a show directory and returns nil, without error, when it doesn't
+// know the show.
+type MetadataProvider interface {
+	Name() string
+	SearchShow(dir string) (*Show, error)
+	Episodes(show *Show) ([]Episode, error)
+	Artwork(show *Show) (Image, error)
+}
+
+// offlineProvider is implemented by providers that only read local files.
+// Asking them is cheap, so their answers aren't cached and an edited file
+// shows on the next run.
+type offlineProvider interface {
+	offline()
+}
+
+func isOffline(provider MetadataProvider) bool {
+	_, ok := provider.(offlineProvider)
+	return ok
+}
+
+func newProvider(name string, logger *log.Entry) (MetadataProvider, error) {
+	switch name {
+	case "tvmaze":
+		return TvMazeClient{logger: logger}, nil
+	case "nfo":
+		return nfoProvider{logger: logger}, nil
+	case "sidecar":
+		return sidecarProvider{logger: logger}, nil
+	}
+	return nil, fmt.Errorf("unknown metadata provider '%s'", name)
+}
+
+// newProviders turns a comma separated list of provider names into providers,
+// in order of preference.
+func newProviders(names string, logger *log.Entry) ([]MetadataProvider, error) {
+	providers := []MetadataProvider{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		provider, err := newProvider(name, logger)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+// fetchShow asks a single provider for everything the writers need.
+func fetchShow(provider MetadataProvider, dir string) (*Show, error) {
+	show, err := provider.SearchShow(dir)
+	if err != nil || show == nil {
+		return nil, err
+	}
+
+	if show.Episodes, err = provider.Episodes(show); err != nil {
+		return nil, err
+	}
+
+	if show.Image, err = provider.Artwork(show); err != nil {
+		return nil, err
+	}
+
+	return show, nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Movie is the provider independent description of a film.
+type Movie struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Year    int    `json:"year"`
+	Summary string `json:"summary"`
+	Image   Image  `json:"image"`
+}
+
+// MovieProvider is a source of film information. SearchMovie returns nil,
+// without error, when it doesn't know the film, and errNotConfigured when it
+// can't be asked at all.
+type MovieProvider interface {
+	Name() string
+	SearchMovie(title string, year int) (*Movie, error)
+}
+
+// errNotConfigured is returned by a provider that misses its configuration,
+// like OMDb without an API key. It knows nothing, but didn't say so either.
+var errNotConfigured = errors.New("provider not configured")
+
+// movie is a film on disk, either a directory holding its video files or a
+// single video file in the movies root.
+type movie struct {
+	Movie
+	// path is the directory or video file the film was found at.
+	path     string
+	videos   []string
+	provider string
+}
+
+type MovieInList struct {
+	Name    string `json:"name"`
+	Year    int    `json:"year"`
+	Summary string `json:"summary"`
+	Image   Image  `json:"image"`
+
+	URL string `json:"url"`
+}
+
+type SingleMovie struct {
+	Name    string `json:"name"`
+	Year    int    `json:"year"`
+	Summary string `json:"summary"`
+	Image   Image  `json:"image"`
+
+	// VideoURL and VideoType describe the preferred source.
+	VideoURL  string        `json:"video_url"`
+	VideoType string        `json:"video_type"`
+	Sources   []videoSource `json:"sources"`
+}
+
+// movieSidecar describes a film by hand, next to or in place of a provider.
+type movieSidecar struct {
+	Name    string `yaml:"name"`
+	Year    int    `yaml:"year"`
+	Summary string `yaml:"summary"`
+	Image   struct {
+		Medium   string `yaml:"medium"`
+		Original string `yaml:"original"`
+	} `yaml:"image"`
+	// Offline stops the other providers from being asked about this film.
+	Offline bool `yaml:"offline"`
+}
+
+// movieYear finds the last year in a name, so 'Blade Runner 2049 (2017)' is
+// from 2017.
+var movieYear = regexp.MustCompile(`^(.*)[ ._(\[]((?:19|20)\d\d)[)\]]?(?:[ ._].*)?$`)
+
+var moviesApp = builtInApp("movies.html")
+var movieApp = builtInApp("movie.html")
+
+func newMovieProvider(name string, logger *log.Entry) (MovieProvider, error) {
+	switch name {
+	case "omdb":
+		return omdbClient{logger: logger}, nil
+	}
+	return nil, fmt.Errorf("unknown movie provider '%s'", name)
+}
+
+func newMovieProviders(names string, logger *log.Entry) ([]MovieProvider, error) {
+	providers := []MovieProvider{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		provider, err := newMovieProvider(name, logger)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+// movieTitle splits a directory or file name like 'Big Buck Bunny (2008).mp4'
+// into title and year.
+func movieTitle(name string) (string, int) {
+	if extensionRank(name, acceptedExtensions()) < len(acceptedExtensions()) {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+
+	if match := movieYear.FindStringSubmatch(name); match != nil {
+		title := strings.TrimRight(match[1], " ._([")
+		if title != "" {
+			year, _ := strconv.Atoi(match[2])
+			return strings.Replace(title, ".", " ", -1), year
+		}
+	}
+	return strings.TrimSpace(name), 0
+}
+
+// movieVideos returns the video files of a film, most preferred first.
+func movieVideos(fileName string, isDir bool) []string {
+	extensions := acceptedExtensions()
+	if !isDir {
+		if extensionRank(fileName, extensions) == len(extensions) {
+			return nil
+		}
+		return []string{fileName}
+	}
+
+	files, err := ioutil.ReadDir(fileName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"path": fileName,
+		}).Error("Error reading movie directory")
+		return nil
+	}
+
+	videos := []string{}
+	for _, file := range files {
+		if !file.IsDir() && extensionRank(file.Name(), extensions) < len(extensions) {
+			videos = append(videos, path.Join(fileName, file.Name()))
+		}
+	}
+	return videos
+}
+
+// movieSidecarFile is 'movie.yaml' in a film directory, or the video file
+// with its extension swapped for '.yaml'.
+func movieSidecarFile(fileName string, isDir bool) string {
+	if isDir {
+		return path.Join(fileName, "movie.yaml")
+	}
+	return strings.TrimSuffix(fileName, path.Ext(fileName)) + ".yaml"
+}
+
+func readMovieSidecar(fileName string) (*movieSidecar, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sidecar := &movieSidecar{}
+	if err := yaml.Unmarshal(data, sidecar); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return sidecar, nil
+}
+
+func (s *movieSidecar) apply(movie *Movie) {
+	if s.Name != "" {
+		movie.Name = s.Name
+	}
+	if s.Year != 0 {
+		movie.Year = s.Year
+	}
+	if s.Summary != "" {
+		movie.Summary = s.Summary
+	}
+	if s.Image.Medium != "" {
+		movie.Image.Medium = s.Image.Medium
+	}
+	if s.Image.Original != "" {
+		movie.Image.Original = s.Image.Original
+	}
+}
+
+// findMatchingMovie looks up a film directory or file in the movies root. A
+// sidecar overrides what the providers say and describes the film when none
+// of them knows it.
+func findMatchingMovie(fileName string, isDir bool) (*movie, error) {
+	contextLogger := log.WithField("file", fileName)
+
+	videos := movieVideos(fileName, isDir)
+	if len(videos) == 0 {
+		contextLogger.Debug("No video, skipping")
+		return nil, nil
+	}
+
+	sidecar, err := readMovieSidecar(movieSidecarFile(fileName, isDir))
+	if err != nil {
+		contextLogger.WithField("err", err).Warn("Ignoring unreadable sidecar")
+	}
+
+	title, year := movieTitle(fileName)
+	found := &movie{path: fileName, videos: videos}
+
+	var matched *Movie
+	if sidecar == nil || !sidecar.Offline {
+		matched, found.provider, err = lookupMovie(fileName, title, year, contextLogger)
+		if err != nil {
+			// Like for shows, a failed lookup is no reason to describe the
+			// film by its sidecar alone.
+			contextLogger.WithField("err", err).Warn("Movie lookup failed")
+			return nil, err
+		}
+	}
+
+	if matched == nil {
+		if sidecar == nil {
+			contextLogger.Debug("No match")
+			return nil, nil
+		}
+		matched = &Movie{ID: fileName, Name: title, Year: year}
+		found.provider = "sidecar"
+	}
+	if sidecar != nil {
+		sidecar.apply(matched)
+	}
+
+	found.Movie = *matched
+	return found, nil
+}
+
+// lookupMovie asks the movie providers about a film, unless the cache has a
+// fresh answer already. That no provider knows the film is only cached when
+// one was actually asked.
+func lookupMovie(fileName, title string, year int, contextLogger *log.Entry) (*Movie, string, error) {
+	if entry, ok := cache.lookupMovie(fileName); ok {
+		contextLogger.Debug("Using cached metadata")
+		return entry.copyMovie(), entry.Provider, nil
+	}
+
+	providers, err := newMovieProviders(movieProviderNames, contextLogger)
+	if err != nil {
+		return nil, "", err
+	}
+
+	asked := false
+	for _, provider := range providers {
+		found, err := provider.SearchMovie(title, year)
+		if err == errNotConfigured {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		asked = true
+		if found != nil {
+			cache.storeMovie(fileName, found, provider.Name())
+			return found, provider.Name(), nil
+		}
+	}
+	if asked {
+		cache.storeMovie(fileName, nil, "")
+	}
+
+	return nil, "", nil
+}
+
+// movieOutputDir is where movie.json and the movie app go.
+func movieOutputDir(movie *movie) string {
+	isFile := len(movie.videos) == 1 && movie.videos[0] == movie.path
+	return movieDir(movie.path, !isFile)
+}
+
+// movieDir is the output directory of the film at fileName. Films kept as a
+// single file get a directory named after the file.
+func movieDir(fileName string, isDir bool) string {
+	if !isDir {
+		return urlify(strings.TrimSuffix(fileName, path.Ext(fileName)))
+	}
+	return fileName
+}
+
+func convertToMovieInList(movie *movie) MovieInList {
+	return MovieInList{
+		Name:    movie.Name,
+		Year:    movie.Year,
+		Summary: movie.Summary,
+		Image:   movie.Image,
+		URL:     documentRoot + movieOutputDir(movie),
+	}
+}
+
+func singleMovie(movie *movie) SingleMovie {
+	sources := videoSources(movie.videos)
+
+	return SingleMovie{
+		Name:      movie.Name,
+		Year:      movie.Year,
+		Summary:   movie.Summary,
+		Image:     movie.Image,
+		VideoURL:  sources[0].URL,
+		VideoType: sources[0].Type,
+		Sources:   sources,
+	}
+}
+
+func writeMovie(movie *movie) {
+	dir := movieOutputDir(movie)
+	if err := makeOutputDir(dir); err != nil {
+		log.WithField("err", err).Error("failed to create movie directory")
+		return
+	}
+
+	single := singleMovie(movie)
+	writeMovieJSON(dir, single)
+	writeMovieApp(dir, single)
+}
+
+func writeMovieJSON(dir string, movie SingleMovie) {
+	data, err := encodeJSON(movie)
+	if err != nil {
+		log.WithField("err", err).Warn("failed to encode")
+		return
+	}
+
+	fileName := path.Join(dir, "movie.json")
+	written, err := writeFile(outputPath(fileName), data)
+	if err != nil {
+		log.WithField("err", err).Warn("failed to write movie.json")
+		return
+	}
+
+	if written {
+		log.WithFields(log.Fields{
+			"file": fileName,
+		}).Info("movie written to disk")
+	}
+}
+
+func writeMovieApp(dir string, movie SingleMovie) {
+	if err := writeApp(path.Join(dir, "index.html"), movieApp, movie); err != nil {
+		log.WithField("err", err).Error("Error writing index.html in movie root")
+	}
+}
+
+// readMoviesJSON returns the films listed by an earlier run, by URL.
+func readMoviesJSON() map[string]MovieInList {
+	previous := map[string]MovieInList{}
+
+	data, err := ioutil.ReadFile(outputPath("movies.json"))
+	if err != nil {
+		return previous
+	}
+
+	movies := []MovieInList{}
+	if err := json.Unmarshal(data, &movies); err != nil {
+		log.WithField("err", err).Warn("Ignoring unreadable movies.json")
+		return previous
+	}
+	for _, movie := range movies {
+		previous[movie.URL] = movie
+	}
+	return previous
+}
+
+func writeMovies(movies []MovieInList) {
+	data, err := encodeJSON(movies)
+	if err != nil {
+		log.WithField("err", err).Error("Error encoding movies.json")
+		return
+	}
+
+	if _, err = writeFile(outputPath("movies.json"), data); err != nil {
+		log.WithField("err", err).Error("Error writing movies.json")
+	}
+
+	if err := writeApp("index.html", moviesApp, movies); err != nil {
+		log.WithField("err", err).Error("Error writing index.html in movies root")
+	}
+}
+
+func loadMoviesApp() error {
+	var err error
+	moviesApp, err = loadTemplate("movies.html")
+	return err
+}
+
+func loadMovieApp() error {
+	var err error
+	movieApp, err = loadTemplate("movie.html")
+	return err
+}
+
+// runMovies is the 'movies' subcommand, the fetcher for a movies root.
+func runMovies(args []string) {
+	loadApps(loadMoviesApp, loadMovieApp)
+
+	if _, err := newMovieProviders(movieProviderNames, nil); err != nil {
+		log.WithField("err", err).Fatal("Invalid -movie-providers")
+	}
+
+	enterMediaRoot(args)
+
+	if err := writeThemeAssets(); err != nil {
+		log.WithField("err", err).Fatal("Error writing theme assets")
+	}
+
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Fatal("Error initializing Fetcher")
+	}
+
+	previous := readMoviesJSON()
+	movies := []MovieInList{}
+	for _, file := range files {
+		if isGeneratedDir(file.Name()) {
+			continue
+		}
+
+		movie, err := findMatchingMovie(file.Name(), file.IsDir())
+		if err != nil {
+			// Keep listing the film as it was, rather than dropping it.
+			if movieInList, ok := previous[documentRoot+movieDir(file.Name(), file.IsDir())]; ok {
+				movies = append(movies, movieInList)
+			}
+			continue
+		}
+		if movie == nil {
+			continue
+		}
+		movie.Image = localImage(movie.Image)
+
+		movies = append(movies, convertToMovieInList(movie))
+		writeMovie(movie)
+	}
+
+	writeMovies(movies)
+
+	if err := cache.save(); err != nil {
+		log.WithField("err", err).Error("Error saving cache")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// nfoProvider reads the Kodi style NFO files some rippers leave behind: a
+// tvshow.nfo in the show directory and an NFO per episode next to the video.
+// It never touches the network.
+type nfoProvider struct {
+	logger *log.Entry
+}
+
+type nfoShow struct {
+	Title  string     `xml:"title"`
+	Plot   string     `xml:"plot"`
+	Thumbs []nfoThumb `xml:"thumb"`
+}
+
+type nfoEpisode struct {
+	XMLName xml.Name   `xml:"episodedetails"`
+	Title   string     `xml:"title"`
+	Season  int        `xml:"season"`
+	Episode int        `xml:"episode"`
+	Plot    string     `xml:"plot"`
+	Aired   string     `xml:"aired"`
+	Thumbs  []nfoThumb `xml:"thumb"`
+}
+
+type nfoThumb struct {
+	Aspect string `xml:"aspect,attr"`
+	URL    string `xml:",chardata"`
+}
+
+// posterFiles are looked for in the show directory when tvshow.nfo doesn't
+// reference a poster.
+var posterFiles = []string{"poster.jpg", "folder.jpg", "poster.png", "folder.png"}
+
+func (n nfoProvider) Name() string {
+	return "nfo"
+}
+
+func (n nfoProvider) offline() {}
+
+func (n nfoProvider) SearchShow(dir string) (*Show, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, "tvshow.nfo"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nfo := nfoShow{}
+	if err := xml.Unmarshal(data, &nfo); err != nil {
+		n.logger.WithField("err", err).Warn("failed to decode tvshow.nfo")
+		return nil, err
+	}
+
+	show := &Show{
+		ID:      dir,
+		Name:    nfo.Title,
+		Summary: nfo.Plot,
+	}
+	if show.Name == "" {
+		show.Name = dir
+	}
+	if thumb := posterThumb(nfo.Thumbs); thumb != "" {
+		show.Image = Image{Medium: thumb, Original: thumb}
+	}
+
+	return show, nil
+}
+
+// Episodes collects every episode NFO below the show directory.
+func (n nfoProvider) Episodes(show *Show) ([]Episode, error) {
+	episodes := []Episode{}
+
+	err := filepath.Walk(show.ID, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(fileName, ".nfo") || info.Name() == "tvshow.nfo" {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+
+		nfo := nfoEpisode{}
+		if err := xml.Unmarshal(data, &nfo); err != nil {
+			n.logger.WithFields(log.Fields{
+				"err":  err,
+				"file": fileName,
+			}).Debug("not an episode NFO, skipping")
+			return nil
+		}
+
+		episode := Episode{
+			Season:  nfo.Season,
+			Number:  nfo.Episode,
+			Name:    nfo.Title,
+			Summary: nfo.Plot,
+			AirDate: nfo.Aired,
+		}
+		if thumb := posterThumb(nfo.Thumbs); thumb != "" {
+			episode.Image = Image{Medium: thumb, Original: thumb}
+		}
+		episodes = append(episodes, episode)
+
+		return nil
+	})
+
+	return episodes, err
+}
+
+// Artwork prefers the poster from tvshow.nfo and falls back to a poster image
+// stored in the show directory.
+func (n nfoProvider) Artwork(show *Show) (Image, error) {
+	if show.Image.Medium != "" {
+		return show.Image, nil
+	}
+	return localPoster(show.ID), nil
+}
+
+// localPoster returns the first poster image found in the show directory.
+func localPoster(dir string) Image {
+	for _, poster := range posterFiles {
+		if _, err := os.Stat(path.Join(dir, poster)); err == nil {
+			url := videoURL(path.Join(dir, poster))
+			return Image{Medium: url, Original: url}
+		}
+	}
+
+	return Image{}
+}
+
+func posterThumb(thumbs []nfoThumb) string {
+	for _, thumb := range thumbs {
+		if thumb.Aspect == "poster" || thumb.Aspect == "" {
+			return strings.TrimSpace(thumb.URL)
+		}
+	}
+	return ""
+}
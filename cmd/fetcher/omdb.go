@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// omdbTimeout bounds a single OMDb request.
+var omdbTimeout = 10 * time.Second
+
+// omdbNotFound is the error OMDb answers with when it doesn't know a film.
+// Any other error, like a reached request limit, is a failed lookup.
+const omdbNotFound = "Movie not found!"
+
+type OmdbMovie struct {
+	ImdbID   string `json:"imdbID"`
+	Title    string `json:"Title"`
+	Year     string `json:"Year"`
+	Plot     string `json:"Plot"`
+	Poster   string `json:"Poster"`
+	Response string `json:"Response"`
+	Error    string `json:"Error"`
+}
+
+// omdbClient looks films up at the OMDb API. It needs an API key in
+// OMDB_API_KEY and can't be asked without one.
+type omdbClient struct {
+	logger *logrus.Entry
+}
+
+func (o omdbClient) Name() string {
+	return "omdb"
+}
+
+func (o omdbClient) SearchMovie(title string, year int) (*Movie, error) {
+	apiKey := os.Getenv("OMDB_API_KEY")
+	if apiKey == "" {
+		o.logger.Debug("OMDB_API_KEY not set, skipping OMDb")
+		return nil, errNotConfigured
+	}
+
+	parameters := url.Values{}
+	parameters.Set("apikey", apiKey)
+	parameters.Set("t", title)
+	parameters.Set("type", "movie")
+	if year != 0 {
+		parameters.Set("y", strconv.Itoa(year))
+	}
+
+	query := o.url() + "?" + parameters.Encode()
+	contextLogger := o.logger.WithField("title", title)
+	contextLogger.Debug("Querying OMDb")
+
+	client := &http.Client{Timeout: omdbTimeout}
+	response, err := client.Get(query)
+	if err != nil {
+		contextLogger.WithField("err", err).Error("Failed to get a response")
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	omdbMovie := &OmdbMovie{}
+	if err := json.NewDecoder(response.Body).Decode(omdbMovie); err != nil {
+		contextLogger.WithField("err", err).Error("Failed to decode")
+		return nil, err
+	}
+
+	if omdbMovie.Response != "True" {
+		if omdbMovie.Error != omdbNotFound {
+			contextLogger.WithField("err", omdbMovie.Error).Error("OMDb refused the request")
+			return nil, fmt.Errorf("omdb: %s", omdbMovie.Error)
+		}
+		contextLogger.Debug("No match found")
+		return nil, nil
+	}
+
+	if !goodEnoughMatch(title, omdbMovie.Title) {
+		contextLogger.WithField("movie", omdbMovie.Title).Debug("Match not good enough")
+		return nil, nil
+	}
+
+	return omdbMovie.convert(), nil
+}
+
+func (o omdbClient) url() string {
+	env := os.Getenv("OMDB_URL")
+	if env == "" {
+		return "http://www.omdbapi.com/"
+	}
+	return env
+}
+
+func (o OmdbMovie) convert() *Movie {
+	movie := &Movie{
+		ID:      o.ImdbID,
+		Name:    o.Title,
+		Summary: o.Plot,
+	}
+	movie.Year, _ = strconv.Atoi(o.Year)
+	if o.Poster != "" && o.Poster != "N/A" {
+		movie.Image = Image{Medium: o.Poster, Original: o.Poster}
+	}
+
+	return movie
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// pinFile is a marker file in a show directory holding the TVMaze ID of the
+// show.
+const pinFile = ".tvmaze"
+
+// pins maps show directories to TVMaze show IDs, bypassing the fuzzy search
+// for shows it gets wrong.
+type pins map[string]int64
+
+func loadPins(fileName string) (pins, error) {
+	pinned := pins{}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return pinned, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &pinned); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	return pinned, nil
+}
+
+func (p pins) save(fileName string) error {
+	data, err := encodeJSON(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFile(fileName, data)
+	return err
+}
+
+// lookup returns the TVMaze ID a show directory is pinned to. A marker file
+// in the directory wins over the mapping file.
+func (p pins) lookup(dir string) (int64, bool, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, pinFile))
+	if err == nil {
+		id, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s: %v", path.Join(dir, pinFile), err)
+		}
+		return id, true, nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+
+	id, ok := p[dir]
+	return id, ok, nil
+}
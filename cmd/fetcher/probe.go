@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// videoInfo is what we know about the encoding of a video file.
+type videoInfo struct {
+	Width   int   `json:"width"`
+	Height  int   `json:"height"`
+	Bitrate int64 `json:"bitrate"`
+	// Duration is in seconds.
+	Duration float64 `json:"duration"`
+}
+
+type probeEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Info    videoInfo `json:"info"`
+}
+
+var resolutionTag = regexp.MustCompile(`(?i)\b(\d{3,4})p\b`)
+
+var ffprobeOnce sync.Once
+var ffprobePath string
+
+// ffprobe returns the path of the ffprobe binary, or an empty string when it
+// isn't installed or disabled with -ffprobe.
+func ffprobe() string {
+	ffprobeOnce.Do(func() {
+		if ffprobeBinary == "" {
+			return
+		}
+
+		found, err := exec.LookPath(ffprobeBinary)
+		if err != nil {
+			log.WithField("err", err).Info("ffprobe not found, guessing video details from file names")
+			return
+		}
+		ffprobePath = found
+	})
+
+	return ffprobePath
+}
+
+// probeVideo reads the resolution and bitrate of a video file. Without
+// ffprobe the resolution is taken from a tag like '720p' in the file name.
+// Results are cached until the file changes.
+func probeVideo(fileName string) videoInfo {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err":  err,
+			"file": fileName,
+		}).Warn("failed to stat video")
+		return videoInfo{}
+	}
+
+	if entry, ok := cache.probe(fileName); ok && entry.Size == stat.Size() && entry.ModTime.Equal(stat.ModTime()) {
+		return entry.Info
+	}
+
+	info := videoInfo{}
+	if binary := ffprobe(); binary != "" {
+		info, err = runFFProbe(binary, fileName)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": fileName,
+			}).Warn("ffprobe failed")
+		}
+	}
+
+	if info.Height == 0 {
+		if match := resolutionTag.FindStringSubmatch(fileName); match != nil {
+			info.Height, _ = strconv.Atoi(match[1])
+		}
+	}
+
+	cache.storeProbe(fileName, probeEntry{
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+		Info:    info,
+	})
+
+	return info
+}
+
+func runFFProbe(binary, fileName string) (videoInfo, error) {
+	output, err := exec.Command(
+		binary,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height,bit_rate:format=bit_rate,duration",
+		"-of", "json",
+		fileName,
+	).Output()
+	if err != nil {
+		return videoInfo{}, err
+	}
+
+	probed := struct {
+		Streams []struct {
+			Width   int    `json:"width"`
+			Height  int    `json:"height"`
+			Bitrate string `json:"bit_rate"`
+		} `json:"streams"`
+		Format struct {
+			Bitrate  string `json:"bit_rate"`
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}{}
+	if err := json.Unmarshal(output, &probed); err != nil {
+		return videoInfo{}, err
+	}
+	if len(probed.Streams) == 0 {
+		return videoInfo{}, fmt.Errorf("no video stream in %s", fileName)
+	}
+
+	info := videoInfo{
+		Width:  probed.Streams[0].Width,
+		Height: probed.Streams[0].Height,
+	}
+	// Containers like WebM rarely record a bitrate per stream.
+	bitrate := probed.Streams[0].Bitrate
+	if bitrate == "" || bitrate == "N/A" {
+		bitrate = probed.Format.Bitrate
+	}
+	info.Bitrate, _ = strconv.ParseInt(bitrate, 10, 64)
+	info.Duration, _ = strconv.ParseFloat(probed.Format.Duration, 64)
+
+	return info, nil
+}
+
+func (v videoInfo) resolution() string {
+	if v.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dp", v.Height)
+}
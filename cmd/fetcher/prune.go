@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// generatedJSON are the JSON files the show fetcher writes. An index.html or
+// a still is only taken for generated when one of them is next to it, so
+// videos, subtitles and the movie pages are never considered.
+var generatedJSON = map[string]bool{
+	"shows.json":   true,
+	"show.json":    true,
+	"season.json":  true,
+	"episode.json": true,
+}
+
+// runPrune is the 'prune' subcommand. It removes the generated files that no
+// longer belong to a video.
+func runPrune(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Only list what would be removed.")
+	flags.Parse(args)
+
+	enterMediaRoot(flags.Args())
+
+	stale, err := staleFiles()
+	if err != nil {
+		log.WithField("err", err).Fatal("Error reading media path")
+	}
+
+	removeStale(stale, *dryRun, os.Stdout)
+
+	// A dry run leaves the output as it is, the cache included.
+	if *dryRun {
+		return
+	}
+	if err := cache.save(); err != nil {
+		log.WithField("err", err).Error("Error saving cache")
+	}
+}
+
+// staleFiles compares the files the fetcher would generate with what's in
+// the output, the media path unless -output is given. Shows that don't match
+// are left alone, a failing provider shouldn't wipe their pages.
+func staleFiles() ([]string, error) {
+	files, err := ioutil.ReadDir(outputPath("."))
+	if err != nil {
+		return nil, err
+	}
+
+	stale := []string{}
+	for _, file := range files {
+		if !file.IsDir() || strings.HasPrefix(file.Name(), ".") || isGeneratedDir(file.Name()) {
+			continue
+		}
+
+		expected := map[string]bool{}
+		if info, err := os.Stat(file.Name()); err == nil && info.IsDir() {
+			show := findMatchingShow(file.Name())
+			if show == nil {
+				log.WithField("dir", file.Name()).Warn("Show doesn't match, not pruning it")
+				continue
+			}
+			for _, fileName := range generatedFiles(show) {
+				expected[fileName] = true
+			}
+		}
+
+		showStale, _ := staleInDir(file.Name(), expected)
+		stale = append(stale, showStale...)
+	}
+
+	return stale, nil
+}
+
+// generatedFiles are the files, relative to the media path, the fetcher
+// writes for a show.
+func generatedFiles(show *show) []string {
+	files := []string{
+		path.Join(show.path, "show.json"),
+		path.Join(show.path, "index.html"),
+	}
+
+	for _, seasonNumber := range seasons(show) {
+		if !hasSeason(show, seasonNumber) {
+			continue
+		}
+
+		seasonDir := seasonOutputDir(show.path, seasonNumber)
+		files = append(files, path.Join(seasonDir, "season.json"), path.Join(seasonDir, "index.html"))
+
+		for _, episode := range episodes(seasonNumber, show) {
+			episodeDir := path.Join(seasonDir, urlify(episode.Name))
+			files = append(files, path.Join(episodeDir, "episode.json"), path.Join(episodeDir, "index.html"))
+		}
+	}
+
+	return files
+}
+
+// staleInDir lists the generated files below dir that aren't expected, and
+// the directories that hold nothing else, children first. It tells whether
+// dir itself would be left empty.
+func staleInDir(dir string, expected map[string]bool) ([]string, bool) {
+	files, err := ioutil.ReadDir(outputPath(dir))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"dir": dir,
+		}).Error("Error reading directory")
+		return nil, false
+	}
+
+	generated := false
+	for _, file := range files {
+		if generatedJSON[file.Name()] && !file.IsDir() {
+			generated = true
+		}
+	}
+
+	stale := []string{}
+	left := len(files)
+	for _, file := range files {
+		fileName := path.Join(dir, file.Name())
+
+		if file.IsDir() {
+			if strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+
+			dirStale, empty := staleInDir(fileName, expected)
+			stale = append(stale, dirStale...)
+			if empty {
+				stale = append(stale, fileName)
+				left--
+			}
+			continue
+		}
+
+		// A still goes with the episode it was grabbed for.
+		if expected[fileName] || (isStill(file.Name()) && expected[path.Join(dir, "episode.json")]) {
+			continue
+		}
+		if generatedJSON[file.Name()] || ((file.Name() == "index.html" || isStill(file.Name())) && generated) {
+			stale = append(stale, fileName)
+			left--
+		}
+	}
+
+	return stale, len(files) > 0 && left == 0
+}
+
+// removeStale removes the stale files, or only lists them on a dry run.
+func removeStale(stale []string, dryRun bool, w io.Writer) {
+	for _, fileName := range stale {
+		if dryRun {
+			fmt.Fprintf(w, "would remove '%s'\n", fileName)
+			continue
+		}
+
+		if err := os.Remove(outputPath(fileName)); err != nil {
+			log.WithFields(log.Fields{
+				"err":  err,
+				"file": fileName,
+			}).Error("Error removing stale file")
+			continue
+		}
+		fmt.Fprintf(w, "removed '%s'\n", fileName)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path"
+	"strings"
+
+	"github.com/haarts/showme/apps"
+)
+
+// appFuncs are the helpers the app templates can use next to their data.
+var appFuncs = template.FuncMap{
+	"asset":       assetURL,
+	"base":        path.Base,
+	"subtitles":   subtitlesURL,
+	"sourceLabel": sourceLabel,
+}
+
+// builtInApp parses an app built into the binary. Those are part of the
+// source, one that doesn't parse is a bug.
+func builtInApp(name string) *template.Template {
+	data, err := apps.FS.ReadFile(name)
+	if err != nil {
+		panic(err)
+	}
+	return template.Must(parseApp(name, data))
+}
+
+func parseApp(name string, data []byte) (*template.Template, error) {
+	return template.New(name).Funcs(appFuncs).Parse(string(data))
+}
+
+// loadTemplate parses an app from -theme, or the one built in when the theme
+// doesn't have it.
+func loadTemplate(name string) (*template.Template, error) {
+	data, err := loadApp(name)()
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := parseApp(name, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return app, nil
+}
+
+// writeApp renders an app with the data of its page, so it works without
+// JavaScript, and writes it to fileName in the output.
+func writeApp(fileName string, app *template.Template, data interface{}) error {
+	var buffer bytes.Buffer
+	if err := app.Execute(&buffer, data); err != nil {
+		return fmt.Errorf("%s: %v", app.Name(), err)
+	}
+
+	_, err := writeFile(outputPath(fileName), buffer.Bytes())
+	return err
+}
+
+// subtitlesURL is where the English subtitles of a video are expected.
+func subtitlesURL(videoURL string) string {
+	return strings.TrimSuffix(videoURL, path.Ext(videoURL)) + ".en.vtt"
+}
+
+// sourceLabel names a source in a list of qualities, like '1080p mp4'.
+func sourceLabel(source videoSource) string {
+	parts := []string{}
+	for _, part := range []string{source.Resolution, source.Container} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return source.URL
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// runReport collects how every show directory was dealt with so it can be
+// summarized once the run is done.
+type runReport struct {
+	resolved    map[string][]reportLine
+	unmatched   []string
+	stale       []reportLine
+	failed      []reportLine
+	unparseable map[string]bool
+	// mutex guards the above, shows are fetched concurrently.
+	mutex sync.Mutex
+}
+
+type reportLine struct {
+	dir  string
+	name string
+}
+
+// reportSections are printed in this order.
+var reportSections = []string{resolvedByPin, resolvedBySearch, resolvedBySidecar}
+
+func newRunReport() *runReport {
+	return &runReport{
+		resolved:    map[string][]reportLine{},
+		unparseable: map[string]bool{},
+	}
+}
+
+func (r *runReport) addShow(dir string, show *show) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if show == nil {
+		r.unmatched = append(r.unmatched, dir)
+		return
+	}
+
+	if show.stale {
+		r.stale = append(r.stale, reportLine{dir: dir, name: show.Name})
+		return
+	}
+
+	r.resolved[show.resolvedBy] = append(r.resolved[show.resolvedBy], reportLine{
+		dir:  dir,
+		name: show.Name,
+	})
+}
+
+// addUnparseable records a video file whose name doesn't tell which episode
+// it holds.
+func (r *runReport) addUnparseable(fileName string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.unparseable[fileName] = true
+}
+
+// addStale records a show whose lookup failed and that was served from what
+// an earlier run learned.
+func (r *runReport) addStale(dir, name string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stale = append(r.stale, reportLine{dir: dir, name: name})
+}
+
+// addFailure records a show directory that couldn't be dealt with at all.
+func (r *runReport) addFailure(dir string, err error) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.failed = append(r.failed, reportLine{dir: dir, name: err.Error()})
+}
+
+// errored tells whether any show lookup failed, whether or not stale data
+// stood in.
+func (r *runReport) errored() bool {
+	return len(r.stale) > 0 || len(r.failed) > 0
+}
+
+func (r *runReport) print(w io.Writer) {
+	for _, resolvedBy := range reportSections {
+		lines := r.resolved[resolvedBy]
+		if len(lines) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "Resolved by %s (%d):\n", resolvedBy, len(lines))
+		for _, line := range lines {
+			fmt.Fprintf(w, "  %s -> %s\n", line.dir, line.name)
+		}
+	}
+
+	if len(r.unmatched) > 0 {
+		fmt.Fprintf(w, "Unmatched (%d):\n", len(r.unmatched))
+		for _, dir := range r.unmatched {
+			fmt.Fprintf(w, "  %s\n", dir)
+		}
+	}
+
+	if len(r.stale) > 0 {
+		fmt.Fprintf(w, "Served from stale data (%d):\n", len(r.stale))
+		for _, line := range r.stale {
+			fmt.Fprintf(w, "  %s -> %s\n", line.dir, line.name)
+		}
+	}
+
+	if len(r.failed) > 0 {
+		fmt.Fprintf(w, "Failed (%d):\n", len(r.failed))
+		for _, line := range r.failed {
+			fmt.Fprintf(w, "  %s: %s\n", line.dir, line.name)
+		}
+	}
+
+	if len(r.unparseable) > 0 {
+		fileNames := []string{}
+		for fileName := range r.unparseable {
+			fileNames = append(fileNames, fileName)
+		}
+		sort.Strings(fileNames)
+
+		fmt.Fprintf(w, "Unparseable file names (%d):\n", len(fileNames))
+		for _, fileName := range fileNames {
+			fmt.Fprintf(w, "  %s\n", fileName)
+		}
+	}
+}
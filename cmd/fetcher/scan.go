@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// scannedDir is a directory as it was read once during a run, with the file
+// names already parsed. Show, season and episode writers all look at the
+// same scan instead of listing the directory again for every episode.
+type scannedDir struct {
+	once  sync.Once
+	dirs  []string
+	files []scannedFile
+}
+
+type scannedFile struct {
+	name   string
+	parsed parsedFilename
+	// ok tells whether the name holds an episode tag at all.
+	ok bool
+}
+
+// dirScans are the directories scanned during the current run.
+type dirScans struct {
+	mutex sync.Mutex
+	dirs  map[string]*scannedDir
+}
+
+func newDirScans() *dirScans {
+	return &dirScans{dirs: map[string]*scannedDir{}}
+}
+
+// scan returns the contents of dir, reading it only the first time it's
+// asked for. A directory that can't be read is logged and comes back empty.
+func (s *dirScans) scan(dir string) *scannedDir {
+	s.mutex.Lock()
+	scanned, ok := s.dirs[dir]
+	if !ok {
+		scanned = &scannedDir{}
+		s.dirs[dir] = scanned
+	}
+	s.mutex.Unlock()
+
+	// Other directories can be scanned while this one is being read.
+	scanned.once.Do(func() {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			log.WithFields(log.Fields{
+				"err": err,
+				"dir": dir,
+			}).Error("Error reading directory")
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				scanned.dirs = append(scanned.dirs, file.Name())
+				continue
+			}
+
+			parsed, ok := parseFilename(file.Name())
+			scanned.files = append(scanned.files, scannedFile{
+				name:   file.Name(),
+				parsed: parsed,
+				ok:     ok,
+			})
+		}
+	})
+
+	return scanned
+}
+
+// reset forgets every scan, for when the media path changed.
+func (s *dirScans) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dirs = map[string]*scannedDir{}
+}
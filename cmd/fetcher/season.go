@@ -1,10 +1,7 @@
 package main
 
 import (
-	"encoding/json"
-	"os"
 	"path"
-	"strconv"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -12,10 +9,7 @@ import (
 type Season struct {
 	Name    string `json:"name"`
 	Summary string `json:"summary"`
-	Image   struct {
-		Medium   string `json:"medium"`
-		Original string `json:"original"`
-	} `json:"image"`
+	Image   Image  `json:"image"`
 
 	Number   int               `json:"number"`
 	Episodes []internalEpisode `json:"episodes"`
@@ -29,44 +23,51 @@ type internalEpisode struct {
 
 func writeSeasons(show *show) {
 	for _, seasonNumber := range seasons(show) {
-		if _, err := os.Stat(path.Join(show.path, strconv.Itoa(seasonNumber))); err != nil {
-			continue
-		}
-
-		writeSeasonJSON(seasonNumber, show)
-		writeSeasonApp(show.path, strconv.Itoa(seasonNumber))
+		writeSeason(seasonNumber, show)
 	}
 }
 
-func writeSeasonApp(showPath, seasonNumber string) {
-	app, err := os.Create(path.Join(showPath, seasonNumber, "index.html"))
-	if err != nil {
-		log.WithField("err", err).Error("Error creating index.html in show root")
+func writeSeason(seasonNumber int, show *show) {
+	if !hasSeason(show, seasonNumber) {
 		return
 	}
-	_, err = app.Write(seasonApp)
-	if err != nil {
-		log.WithField("err", err).Error("Error writing index.html in show root")
+
+	if err := makeOutputDir(seasonOutputDir(show.path, seasonNumber)); err != nil {
+		log.WithField("err", err).Error("failed to create season directory")
 		return
 	}
+
+	season := season(seasonNumber, show)
+	writeSeasonJSON(show.path, season)
+	writeSeasonApp(show.path, season)
 }
 
-func writeSeasonJSON(seasonNumber int, show *show) {
-	file, err := os.Create(path.Join(show.path, strconv.Itoa(seasonNumber), "season.json"))
-	if err != nil {
-		log.WithField("err", err).Warn("failed to create show.json")
+func writeSeasonApp(showPath string, season Season) {
+	if err := writeApp(path.Join(seasonOutputDir(showPath, season.Number), "index.html"), seasonApp, season); err != nil {
+		log.WithField("err", err).Error("Error writing index.html in season root")
 		return
 	}
-	defer file.Close()
+}
 
-	if err := json.NewEncoder(file).Encode(season(seasonNumber, show)); err != nil {
+func writeSeasonJSON(showPath string, season Season) {
+	data, err := encodeJSON(season)
+	if err != nil {
 		log.WithField("err", err).Warn("failed to encode")
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"file": file.Name(),
-	}).Info("season written to disk")
+	fileName := path.Join(seasonOutputDir(showPath, season.Number), "season.json")
+	written, err := writeFile(outputPath(fileName), data)
+	if err != nil {
+		log.WithField("err", err).Warn("failed to write season.json")
+		return
+	}
+
+	if written {
+		log.WithFields(log.Fields{
+			"file": fileName,
+		}).Info("season written to disk")
+	}
 }
 
 func season(number int, show *show) Season {
@@ -78,25 +79,26 @@ func season(number int, show *show) Season {
 	}
 
 	episodes := []internalEpisode{}
+	dirs := videoDirs(show.path, number)
 
-	for _, episode := range show.Embedded.Episodes {
-		if int(episode.Season) != number {
+	for _, episode := range show.Episodes {
+		if episode.Season != number {
 			continue
 		}
 
 		// Check if episode exists on disk
-		if !episodeExists(path.Join(show.path, strconv.Itoa(number)), episode) {
+		if !episodeExists(dirs, episode) {
 			continue
 		}
 
 		episodes = append(episodes, internalEpisode{
 			commonEpisode: commonEpisode{
-				Number:  int(episode.Episode),
+				Number:  episode.Number,
 				Name:    episode.Name,
 				Summary: episode.Summary,
 				Image:   episode.Image,
 			},
-			URL: documentRoot + path.Join(show.path, strconv.Itoa(number), urlify(episode.Name)),
+			URL: documentRoot + path.Join(seasonOutputDir(show.path, number), urlify(episode.Name)),
 		})
 	}
 
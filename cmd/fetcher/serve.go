@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// contentTypes are the types served for the files the fetcher writes. Like
+// videoTypes they aren't left to the mime package.
+var contentTypes = map[string]string{
+	".json": "application/json",
+	".html": "text/html; charset=utf-8",
+	".vtt":  "text/vtt",
+	".srt":  "application/x-subrip",
+	// The assets of a theme.
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+}
+
+// mediaHandler serves a media root: the videos and the JSON and apps the
+// fetcher generated. A file is looked for in every root in turn, so the
+// generated site can live apart from the videos.
+type mediaHandler struct {
+	roots []string
+}
+
+// runServe is the 'serve' subcommand. It serves a media root under
+// -document-root so no other webserver is needed.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", ":8080", "Address to listen on.")
+	certFile := flags.String("tls-cert", "", "TLS certificate file. Serves HTTPS together with -tls-key.")
+	keyFile := flags.String("tls-key", "", "TLS key file.")
+	api := flags.Bool("api", false, "Serve the show structures from memory under "+apiRoot+" too.")
+	flags.Parse(args)
+
+	if (*certFile == "") != (*keyFile == "") {
+		log.Fatal("-tls-cert and -tls-key go together")
+	}
+
+	enterMediaRoot(flags.Args())
+
+	// The generated JSON points to URLs under -document-root.
+	prefix := strings.TrimSuffix(documentRoot, "/")
+	mux := http.NewServeMux()
+	roots := []string{"."}
+	if outputDir != "" {
+		roots = []string{outputDir, "."}
+	}
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, mediaHandler{roots: roots}))
+	if mediaURL != "" && mediaURL != documentRoot {
+		prefix := strings.TrimSuffix(mediaURL, "/")
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, mediaHandler{roots: []string{"."}}))
+	}
+
+	if *api {
+		index := newLibraryIndex()
+		if err := index.build(); err != nil {
+			log.WithField("err", err).Fatal("Error indexing media path")
+		}
+		mux.Handle(apiRoot+"/", index)
+	}
+
+	server := &http.Server{
+		Addr:        *listen,
+		Handler:     mux,
+		ReadTimeout: time.Minute,
+	}
+
+	contextLogger := log.WithFields(log.Fields{
+		"listen": *listen,
+		"root":   flags.Arg(0),
+	})
+	var err error
+	if *certFile != "" {
+		contextLogger.Info("Serving HTTPS")
+		err = server.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		contextLogger.Info("Serving HTTP")
+		err = server.ListenAndServe()
+	}
+	log.WithField("err", err).Fatal("Server stopped")
+}
+
+// directoryURL is the URL of the requested directory with a trailing slash.
+// It's built from the URI as requested, StripPrefix has taken the document
+// root off r.URL.
+func directoryURL(r *http.Request) string {
+	requested := r.URL
+	if parsed, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		requested = parsed
+	}
+
+	location := requested.EscapedPath() + "/"
+	if requested.RawQuery != "" {
+		location += "?" + requested.RawQuery
+	}
+	return location
+}
+
+func (h mediaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	// The cache, pins and sidecars like .tvmaze are nobody's business.
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	for _, root := range h.roots {
+		fileName := path.Join(root, name)
+		info, err := os.Stat(fileName)
+		if err != nil {
+			continue
+		}
+
+		if info.IsDir() {
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				http.Redirect(w, r, directoryURL(r), http.StatusMovedPermanently)
+				return
+			}
+
+			fileName = path.Join(fileName, "index.html")
+			if info, err = os.Stat(fileName); err != nil || info.IsDir() {
+				continue
+			}
+		}
+
+		h.serveFile(w, r, fileName, info)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// serveFile leaves ranges and conditional requests to http.ServeContent. JSON
+// is small enough to compress in memory, the compressed bytes get their own
+// ETag.
+func (h mediaHandler) serveFile(w http.ResponseWriter, r *http.Request, fileName string, info os.FileInfo) {
+	extension := strings.ToLower(path.Ext(fileName))
+	w.Header().Set("Content-Type", contentType(extension))
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+
+	if extension == ".json" {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			data, err := gzipFile(fileName)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err":  err,
+					"file": fileName,
+				}).Error("Error compressing file")
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+			http.ServeContent(w, r, fileName, info.ModTime(), bytes.NewReader(data))
+			return
+		}
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, fileName, info.ModTime(), file)
+}
+
+func contentType(extension string) string {
+	if contentType, ok := contentTypes[extension]; ok {
+		return contentType
+	}
+	if contentType := videoType(extension); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+		if encoding == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipFile(fileName string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -1,10 +1,7 @@
 package main
 
 import (
-	"encoding/json"
-	"os"
 	"path"
-	"strconv"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -12,33 +9,52 @@ import (
 type SingleShow struct {
 	Name    string `json:"name"`
 	Summary string `json:"summary"`
-	Image   struct {
-		Medium   string `json:"medium"`
-		Original string `json:"original"`
-	} `json:"image"`
+	Image   Image  `json:"image"`
 
 	SeasonURLs []string `json:"season_urls"`
 }
 
 func writeShow(show *show) {
+	if err := makeOutputDir(show.path); err != nil {
+		log.WithField("err", err).Error("failed to create show directory")
+		return
+	}
+
 	writeShowJSON(show)
-	writeShowApp(show.path)
+	writeShowApp(show.path, singleShow(show))
 }
 
-func writeShowApp(showName string) {
-	app, err := os.Create(path.Join(showName, "index.html"))
-	if err != nil {
-		log.WithField("err", err).Error("Error creating index.html in show root")
-		return
-	}
-	_, err = app.Write(showApp)
-	if err != nil {
+func writeShowApp(showPath string, show SingleShow) {
+	if err := writeApp(path.Join(showPath, "index.html"), showApp, show); err != nil {
 		log.WithField("err", err).Error("Error writing index.html in show root")
 		return
 	}
 }
 
 func writeShowJSON(show *show) error {
+	data, err := encodeJSON(singleShow(show))
+	if err != nil {
+		log.WithField("err", err).Warn("failed to encode")
+		return err
+	}
+
+	fileName := path.Join(show.path, "show.json")
+	written, err := writeFile(outputPath(fileName), data)
+	if err != nil {
+		log.WithField("err", err).Warn("failed to write show.json")
+		return err
+	}
+
+	if written {
+		log.WithFields(log.Fields{
+			"file": fileName,
+		}).Info("show written to disk")
+	}
+
+	return nil
+}
+
+func singleShow(show *show) SingleShow {
 	singleShow := SingleShow{
 		Name:       show.Name,
 		Summary:    show.Summary,
@@ -47,26 +63,10 @@ func writeShowJSON(show *show) error {
 	}
 
 	for _, season := range seasons(show) {
-		if _, err := os.Stat(path.Join(show.path, strconv.Itoa(season))); err == nil {
-			singleShow.SeasonURLs = append(singleShow.SeasonURLs, documentRoot+show.path+"/"+strconv.Itoa(season))
+		if hasSeason(show, season) {
+			singleShow.SeasonURLs = append(singleShow.SeasonURLs, documentRoot+seasonOutputDir(show.path, season))
 		}
 	}
 
-	file, err := os.Create(path.Join(show.path, "show.json"))
-	if err != nil {
-		log.WithField("err", err).Warn("failed to create show.json")
-		return err
-	}
-	defer file.Close()
-
-	if err := json.NewEncoder(file).Encode(singleShow); err != nil {
-		log.WithField("err", err).Warn("failed to encode")
-		return err
-	}
-
-	log.WithFields(log.Fields{
-		"file": file.Name(),
-	}).Info("show written to disk")
-
-	return nil
+	return singleShow
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"os"
+	"io/ioutil"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -10,10 +10,7 @@ import (
 type ShowInList struct {
 	Name    string `json:"name"`
 	Summary string `json:"summary"`
-	Image   struct {
-		Medium   string `json:"medium"`
-		Original string `json:"original"`
-	} `json:"image"`
+	Image   Image  `json:"image"`
 
 	URL string `json:"url"`
 }
@@ -27,27 +24,41 @@ func convertToShowInList(show *show) ShowInList {
 	}
 }
 
+// readShowsJSON returns the shows listed by an earlier run, by URL.
+func readShowsJSON() map[string]ShowInList {
+	previous := map[string]ShowInList{}
+
+	data, err := ioutil.ReadFile(outputPath("shows.json"))
+	if err != nil {
+		return previous
+	}
+
+	shows := []ShowInList{}
+	if err := json.Unmarshal(data, &shows); err != nil {
+		log.WithField("err", err).Warn("Ignoring unreadable shows.json")
+		return previous
+	}
+	for _, show := range shows {
+		previous[show.URL] = show
+	}
+	return previous
+}
+
 func writeShowsJSON(shows []ShowInList) {
-	file, err := os.Create("shows.json")
+	data, err := encodeJSON(shows)
 	if err != nil {
-		log.WithField("err", err).Error("Error creating shows.json")
+		log.WithField("err", err).Error("Error encoding shows.json")
 		return
 	}
 
-	if err = json.NewEncoder(file).Encode(shows); err != nil {
+	if _, err = writeFile(outputPath("shows.json"), data); err != nil {
 		log.WithField("err", err).Error("Error writing shows.json")
 		return
 	}
 }
 
-func writeShowsApp() {
-	app, err := os.Create("index.html")
-	if err != nil {
-		log.WithField("err", err).Error("Error creating index.html in shows root")
-		return
-	}
-	_, err = app.Write(showsApp)
-	if err != nil {
+func writeShowsApp(shows []ShowInList) {
+	if err := writeApp("index.html", showsApp, shows); err != nil {
 		log.WithField("err", err).Error("Error writing index.html in shows root")
 		return
 	}
@@ -55,5 +66,5 @@ func writeShowsApp() {
 
 func writeShows(shows []ShowInList) {
 	writeShowsJSON(shows)
-	writeShowsApp()
+	writeShowsApp(shows)
 }
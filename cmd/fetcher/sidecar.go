@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// sidecarFiles are looked for, in this order, in every show directory. Note
+// that show.json can't be used; that's where the show app reads its data from.
+var sidecarFiles = []string{"show.yaml", "show.yml"}
+
+// sidecar is a hand written description of a show. It either describes the
+// show completely, for home recordings no provider knows about, or overrides
+// individual fields a provider got wrong.
+type sidecar struct {
+	Name    string `yaml:"name"`
+	Summary string `yaml:"summary"`
+	Image   struct {
+		Medium   string `yaml:"medium"`
+		Original string `yaml:"original"`
+	} `yaml:"image"`
+	// Offline stops the other providers from being asked about this show.
+	Offline  bool             `yaml:"offline"`
+	Episodes []sidecarEpisode `yaml:"episodes"`
+}
+
+type sidecarEpisode struct {
+	Season  int    `yaml:"season"`
+	Number  int    `yaml:"number"`
+	Name    string `yaml:"name"`
+	Summary string `yaml:"summary"`
+	AirDate string `yaml:"airdate"`
+	Image   struct {
+		Medium   string `yaml:"medium"`
+		Original string `yaml:"original"`
+	} `yaml:"image"`
+}
+
+// readSidecar returns the show sidecar in dir, or nil when there is none.
+func readSidecar(dir string) (*sidecar, error) {
+	for _, fileName := range sidecarFiles {
+		data, err := ioutil.ReadFile(path.Join(dir, fileName))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s := &sidecar{}
+		if err := yaml.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("%s: %v", path.Join(dir, fileName), err)
+		}
+		return s, nil
+	}
+
+	return nil, nil
+}
+
+// readEpisodeSidecars collects the per episode sidecars below dir. These are
+// YAML files named after the episode, like 'S01E02.yaml' or a video file with
+// its extension swapped. Season and number in the file win over the name.
+func readEpisodeSidecars(dir string) ([]sidecarEpisode, error) {
+	episodes := []sidecarEpisode{}
+
+	err := filepath.Walk(dir, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isSidecarEpisode(dir, fileName) {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+
+		episode := sidecarEpisode{}
+		if err := yaml.Unmarshal(data, &episode); err != nil {
+			return fmt.Errorf("%s: %v", fileName, err)
+		}
+
+		if parsed, ok := parseFilename(info.Name()); ok && len(parsed.Episodes) > 0 {
+			if episode.Season == 0 {
+				episode.Season = parsed.Season
+			}
+			if episode.Number == 0 {
+				episode.Number = parsed.Episodes[0]
+			}
+		}
+		if episode.Season == 0 || episode.Number == 0 {
+			log.WithField("file", fileName).Warn("episode sidecar without season or number, skipping")
+			return nil
+		}
+
+		episodes = append(episodes, episode)
+		return nil
+	})
+
+	return episodes, err
+}
+
+func isSidecarEpisode(dir, fileName string) bool {
+	if path.Dir(fileName) == dir {
+		// Only show sidecars live in the show directory itself.
+		return false
+	}
+
+	extension := path.Ext(fileName)
+	return extension == ".yaml" || extension == ".yml"
+}
+
+// apply overrides the fields of show that the sidecar sets.
+func (s *sidecar) apply(show *Show) {
+	if s.Name != "" {
+		show.Name = s.Name
+	}
+	if s.Summary != "" {
+		show.Summary = s.Summary
+	}
+	if s.Image.Medium != "" {
+		show.Image.Medium = s.Image.Medium
+	}
+	if s.Image.Original != "" {
+		show.Image.Original = s.Image.Original
+	}
+
+	applyEpisodeSidecars(show, s.Episodes)
+}
+
+// applyEpisodeSidecars overrides the episodes of show with the sidecars
+// describing them and adds the ones the show doesn't have yet.
+func applyEpisodeSidecars(show *Show, episodeSidecars []sidecarEpisode) {
+	for _, override := range episodeSidecars {
+		found := false
+		for i := range show.Episodes {
+			episode := &show.Episodes[i]
+			if episode.Season == override.Season && episode.Number == override.Number {
+				override.applyTo(episode)
+				found = true
+			}
+		}
+
+		if !found {
+			episode := Episode{
+				Season: override.Season,
+				Number: override.Number,
+			}
+			override.applyTo(&episode)
+			show.Episodes = append(show.Episodes, episode)
+		}
+	}
+}
+
+func (s sidecarEpisode) applyTo(episode *Episode) {
+	if s.Name != "" {
+		episode.Name = s.Name
+	}
+	if s.Summary != "" {
+		episode.Summary = s.Summary
+	}
+	if s.AirDate != "" {
+		episode.AirDate = s.AirDate
+	}
+	if s.Image.Medium != "" {
+		episode.Image.Medium = s.Image.Medium
+	}
+	if s.Image.Original != "" {
+		episode.Image.Original = s.Image.Original
+	}
+}
+
+// sidecarProvider builds shows from sidecars alone.
+type sidecarProvider struct {
+	logger *log.Entry
+}
+
+func (s sidecarProvider) Name() string {
+	return "sidecar"
+}
+
+func (s sidecarProvider) offline() {}
+
+func (s sidecarProvider) SearchShow(dir string) (*Show, error) {
+	sidecar, err := readSidecar(dir)
+	if err != nil || sidecar == nil {
+		return nil, err
+	}
+
+	show := &Show{
+		ID:       dir,
+		Name:     strings.TrimSpace(path.Base(dir)),
+		Episodes: []Episode{},
+	}
+	sidecar.apply(show)
+
+	return show, nil
+}
+
+func (s sidecarProvider) Episodes(show *Show) ([]Episode, error) {
+	episodeSidecars, err := readEpisodeSidecars(show.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	applyEpisodeSidecars(show, episodeSidecars)
+
+	return show.Episodes, nil
+}
+
+func (s sidecarProvider) Artwork(show *Show) (Image, error) {
+	if show.Image.Medium != "" {
+		return show.Image, nil
+	}
+	return localPoster(show.ID), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// stillWidth is the width of the frames grabbed from episodes, big enough for
+// the episode page and fine for lists.
+const stillWidth = 640
+
+var ffmpegOnce sync.Once
+var ffmpegPath string
+
+// ffmpeg returns the path of the ffmpeg binary, or an empty string when it
+// isn't installed or disabled with -ffmpeg.
+func ffmpeg() string {
+	ffmpegOnce.Do(func() {
+		if ffmpegBinary == "" {
+			return
+		}
+
+		found, err := exec.LookPath(ffmpegBinary)
+		if err != nil {
+			log.WithField("err", err).Info("ffmpeg not found, episodes without artwork stay without")
+			return
+		}
+		ffmpegPath = found
+	})
+
+	return ffmpegPath
+}
+
+// isStill tells whether fileName is a frame grabbed from an episode.
+func isStill(fileName string) bool {
+	return strings.HasPrefix(fileName, "still-") && path.Ext(fileName) == ".jpg"
+}
+
+// episodeStill grabs a frame of video, of an episode that has no artwork,
+// and returns it as its image. The frame is stored next to the episode
+// output, named after the video file as it is now, so it's only grabbed
+// again when the video changes.
+func episodeStill(show *show, episode Episode, video string) Image {
+	binary := ffmpeg()
+	if binary == "" {
+		return Image{}
+	}
+
+	stat, err := os.Stat(video)
+	if err != nil {
+		return Image{}
+	}
+
+	episodeDir := path.Join(seasonOutputDir(show.path, episode.Season), urlify(episode.Name))
+	version := fmt.Sprintf("%s %d %d", video, stat.Size(), stat.ModTime().UnixNano())
+	fileName := path.Join(episodeDir, "still-"+fmt.Sprintf("%x", sha1.Sum([]byte(version)))[:16]+".jpg")
+
+	contextLogger := log.WithFields(log.Fields{
+		"file":  video,
+		"still": fileName,
+	})
+
+	if _, err := os.Stat(outputPath(fileName)); os.IsNotExist(err) {
+		if err := makeOutputDir(episodeDir); err != nil {
+			contextLogger.WithField("err", err).Warn("Error creating episode directory")
+			return Image{}
+		}
+		if err := grabFrame(binary, video, outputPath(fileName), probeVideo(video)); err != nil {
+			contextLogger.WithField("err", err).Warn("Error grabbing frame from video")
+			return Image{}
+		}
+		contextLogger.Debug("frame grabbed from video")
+		removeOldStills(episodeDir, path.Base(fileName))
+	}
+
+	return Image{
+		Medium:   documentRoot + fileName,
+		Original: documentRoot + fileName,
+	}
+}
+
+// grabFrame has ffmpeg write a representative frame of video, from about a
+// third in, past the opening titles, to fileName.
+func grabFrame(binary, video, fileName string, info videoInfo) error {
+	temp := filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".tmp")
+	defer os.Remove(temp)
+
+	grab := func(offset float64) error {
+		output, err := exec.Command(
+			binary,
+			"-v", "error",
+			"-ss", strconv.FormatFloat(offset, 'f', 3, 64),
+			"-i", video,
+			"-vf", fmt.Sprintf("thumbnail,scale='min(%d,iw)':-2", stillWidth),
+			"-frames:v", "1",
+			"-f", "image2",
+			"-y", temp,
+		).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+		}
+		// ffmpeg succeeds without writing anything when seeking past the end.
+		if _, err := os.Stat(temp); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	err := grab(info.Duration / 3)
+	if err != nil && info.Duration > 0 {
+		err = grab(0)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(temp)
+	if err != nil {
+		return err
+	}
+	_, err = writeFile(fileName, data)
+	return err
+}
+
+// removeOldStills removes the frames grabbed from an earlier version of the
+// video.
+func removeOldStills(episodeDir, current string) {
+	matches, err := filepath.Glob(filepath.Join(outputPath(episodeDir), "still-*.jpg"))
+	if err != nil {
+		return
+	}
+	for _, match := range matches {
+		if filepath.Base(match) != current {
+			os.Remove(match)
+		}
+	}
+}
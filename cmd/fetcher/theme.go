@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/haarts/showme/apps"
+)
+
+// themeAssetsDir is where the assets of the theme are copied to, in the
+// root of the output. It's no show or film.
+const themeAssetsDir = "_theme"
+
+// checkThemeDir tells why -theme can't be used, if it can't.
+func checkThemeDir() error {
+	info, err := os.Stat(themeDir)
+	if err != nil {
+		return fmt.Errorf("-theme: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("-theme: %s is not a directory", themeDir)
+	}
+	return nil
+}
+
+// assetURL is the URL of a file in the assets of the theme.
+func assetURL(name string) string {
+	return documentRoot + path.Join(themeAssetsDir, name)
+}
+
+// writeThemeAssets copies the assets of the built in theme to the output
+// and, over them, those of -theme. A theme only has to bring what it
+// changes.
+func writeThemeAssets() error {
+	builtIn, err := fs.Sub(apps.FS, "assets")
+	if err != nil {
+		return err
+	}
+	if err := copyAssets(builtIn); err != nil {
+		return err
+	}
+
+	if themeDir == "" {
+		return nil
+	}
+	if err := checkThemeDir(); err != nil {
+		return err
+	}
+
+	assetsDir := path.Join(themeDir, "assets")
+	if _, err := os.Stat(assetsDir); os.IsNotExist(err) {
+		return nil
+	}
+	return copyAssets(os.DirFS(assetsDir))
+}
+
+func copyAssets(assets fs.FS) error {
+	return fs.WalkDir(assets, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		data, err := fs.ReadFile(assets, name)
+		if err != nil {
+			return err
+		}
+
+		fileName := path.Join(themeAssetsDir, name)
+		if err := makeOutputDir(path.Dir(fileName)); err != nil {
+			return err
+		}
+		written, err := writeFile(outputPath(fileName), data)
+		if err != nil {
+			return err
+		}
+
+		if written {
+			log.WithField("file", fileName).Debug("theme asset written to disk")
+		}
+		return nil
+	})
+}
@@ -1,30 +1,53 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
+// tvmazeLimiter is shared by every request to TVMaze, whichever worker makes
+// it. TVMaze allows 20 calls every 10 seconds.
+var tvmazeLimiter = rate.NewLimiter(rate.Every(10*time.Second/20), 1)
+
+// tvmazeBackoff is the wait before the first retry, it doubles with every
+// next one.
+var tvmazeBackoff = time.Second
+
+const tvmazeMaxBackoff = time.Minute
+
 type TvMazeEpisode struct {
 	ID      int64  `json:"id"`
 	Name    string `json:"name"`
 	Season  int64  `json:"season"`
 	Episode int64  `json:"number"`
 	Summary string `json:"summary"`
-	//AirDate time.Time `json:"airdate"`
-	Image struct {
+	AirDate string `json:"airdate"`
+	Image   struct {
 		Medium   string `json:"medium"`
 		Original string `json:"original"`
 	} `json:"image"`
 }
 
 type TvMazeShow struct {
-	Name  string `json:"name"`
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Premiered string `json:"premiered"`
+	Network   *struct {
+		Name string `json:"name"`
+	} `json:"network"`
+	WebChannel *struct {
+		Name string `json:"name"`
+	} `json:"webChannel"`
 	Image struct {
 		Medium   string `json:"medium"`
 		Original string `json:"original"`
@@ -35,24 +58,144 @@ type TvMazeShow struct {
 	} `json:"_embedded"`
 }
 
+type TvMazeSearchResult struct {
+	Score float64    `json:"score"`
+	Show  TvMazeShow `json:"show"`
+}
+
 type TvMazeClient struct {
 	logger *logrus.Entry
 }
 
+func (t TvMazeClient) Name() string {
+	return "tvmaze"
+}
+
+// SearchShow uses the single search endpoint, which embeds the episodes, so
+// Episodes doesn't need another round trip.
+func (t TvMazeClient) SearchShow(dir string) (*Show, error) {
+	tvMazeShow, err := t.Find(dir)
+	if err != nil || tvMazeShow == nil {
+		return nil, err
+	}
+
+	if !goodEnoughMatch(dir, tvMazeShow.Name) {
+		t.logger.WithField("show", tvMazeShow.Name).Debug("Match not good enough")
+		return nil, nil
+	}
+
+	return tvMazeShow.convert(), nil
+}
+
+func (t TvMazeClient) Episodes(show *Show) ([]Episode, error) {
+	if show.Episodes != nil {
+		return show.Episodes, nil
+	}
+
+	query := fmt.Sprintf(t.episodesURLTemplate(), show.ID)
+	contextLogger := t.logger.WithField("url", query)
+	contextLogger.Debug("Querying TVMaze for episodes")
+
+	response, err := t.get(query)
+	if err != nil {
+		contextLogger.WithField("err", err).Error("Failed to get a response")
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		contextLogger.WithField("status", response.StatusCode).Error("Unexpected response")
+		return nil, fmt.Errorf("TVMaze episodes of %s: %s", show.ID, response.Status)
+	}
+
+	tvMazeEpisodes := []TvMazeEpisode{}
+	if err := json.NewDecoder(response.Body).Decode(&tvMazeEpisodes); err != nil {
+		contextLogger.WithField("err", err).Error("Failed to decode")
+		return nil, err
+	}
+
+	return convertTvMazeEpisodes(tvMazeEpisodes), nil
+}
+
+// Artwork returns the poster TVMaze sent along with the show.
+func (t TvMazeClient) Artwork(show *Show) (Image, error) {
+	return show.Image, nil
+}
+
 func (t TvMazeClient) Find(q string) (*TvMazeShow, error) {
 	query := fmt.Sprintf(t.urlTemplate(), url.QueryEscape(q))
 	contextLogger := t.logger.WithField("url", query)
 	contextLogger.Debug("Querying TVMaze")
 
-	response, err := http.Get(query)
+	response, err := t.get(query)
 	if err != nil {
 		contextLogger.WithField("err", err).Error("Failed to get a response")
 		return nil, err
 	}
-	if response.StatusCode == 404 {
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
 		contextLogger.Warn("No match found")
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		contextLogger.WithField("status", response.StatusCode).Error("Unexpected response")
+		return nil, fmt.Errorf("TVMaze search for '%s': %s", q, response.Status)
+	}
+
+	show := &TvMazeShow{}
+	if err := json.NewDecoder(response.Body).Decode(show); err != nil {
+		contextLogger.WithField("err", err).Error("Failed to decode")
+		return nil, err
+	}
+
+	return show, nil
+}
+
+// Search returns every show TVMaze considers a match, best match first.
+func (t TvMazeClient) Search(q string) ([]TvMazeSearchResult, error) {
+	query := fmt.Sprintf(t.searchURLTemplate(), url.QueryEscape(q))
+	contextLogger := t.logger.WithField("url", query)
+	contextLogger.Debug("Searching TVMaze")
+
+	response, err := t.get(query)
+	if err != nil {
+		contextLogger.WithField("err", err).Error("Failed to get a response")
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		contextLogger.WithField("status", response.StatusCode).Error("Unexpected response")
+		return nil, fmt.Errorf("TVMaze search for '%s': %s", q, response.Status)
+	}
+
+	results := []TvMazeSearchResult{}
+	if err := json.NewDecoder(response.Body).Decode(&results); err != nil {
+		contextLogger.WithField("err", err).Error("Failed to decode")
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Lookup fetches a show, with its episodes, by TVMaze ID.
+func (t TvMazeClient) Lookup(id int64) (*TvMazeShow, error) {
+	query := fmt.Sprintf(t.showURLTemplate(), id)
+	contextLogger := t.logger.WithField("url", query)
+	contextLogger.Debug("Querying TVMaze by ID")
+
+	response, err := t.get(query)
+	if err != nil {
+		contextLogger.WithField("err", err).Error("Failed to get a response")
 		return nil, err
 	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		contextLogger.WithField("status", response.StatusCode).Error("Unexpected response")
+		return nil, fmt.Errorf("TVMaze show %d: %s", id, response.Status)
+	}
 
 	show := &TvMazeShow{}
 	if err := json.NewDecoder(response.Body).Decode(show); err != nil {
@@ -63,6 +206,67 @@ func (t TvMazeClient) Find(q string) (*TvMazeShow, error) {
 	return show, nil
 }
 
+// get requests query within the shared rate limit. Requests that time out,
+// are rate limited or hit a server error are retried with exponential
+// backoff; after the last retry the response is returned as is.
+func (t TvMazeClient) get(query string) (*http.Response, error) {
+	client := &http.Client{Timeout: tvmazeTimeout}
+
+	for attempt := 0; ; attempt++ {
+		if err := tvmazeLimiter.Wait(context.Background()); err != nil {
+			return nil, err
+		}
+
+		response, err := client.Get(query)
+		if attempt >= tvmazeRetries || (err == nil && !retryable(response.StatusCode)) {
+			return response, err
+		}
+
+		wait := backoff(attempt)
+		contextLogger := t.logger.WithFields(logrus.Fields{
+			"url":     query,
+			"attempt": attempt + 1,
+		})
+		if err != nil {
+			contextLogger = contextLogger.WithField("err", err)
+		} else {
+			wait = retryAfter(response.Header.Get("Retry-After"), wait)
+			contextLogger = contextLogger.WithField("status", response.StatusCode)
+			response.Body.Close()
+		}
+		contextLogger.WithField("wait", wait).Warn("TVMaze request failed, retrying")
+		time.Sleep(wait)
+	}
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// backoff doubles the wait with every attempt. Half of it is random, so
+// workers that were turned away together don't come back together.
+func backoff(attempt int) time.Duration {
+	wait := tvmazeBackoff << uint(attempt)
+	if wait <= 0 || wait > tvmazeMaxBackoff {
+		wait = tvmazeMaxBackoff
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// retryAfter is the wait asked for by a Retry-After header in seconds, capped
+// like the backoff so one odd response can't hold up every worker, or wait
+// when there's no such header.
+func retryAfter(header string, wait time.Duration) time.Duration {
+	after, err := strconv.Atoi(header)
+	if err != nil || after <= 0 {
+		return wait
+	}
+	if after > int(tvmazeMaxBackoff/time.Second) {
+		return tvmazeMaxBackoff
+	}
+	return time.Duration(after) * time.Second
+}
+
 func (t TvMazeClient) urlTemplate() string {
 	env := os.Getenv("TVMAZE_URL_TEMPLATE")
 	if env == "" {
@@ -72,3 +276,74 @@ func (t TvMazeClient) urlTemplate() string {
 	}
 	return env
 }
+
+func (t TvMazeClient) episodesURLTemplate() string {
+	env := os.Getenv("TVMAZE_EPISODES_URL_TEMPLATE")
+	if env == "" {
+		return "http://api.tvmaze.com/shows/%s/episodes"
+	}
+	return env
+}
+
+func (t TvMazeClient) searchURLTemplate() string {
+	env := os.Getenv("TVMAZE_SEARCH_URL_TEMPLATE")
+	if env == "" {
+		return "http://api.tvmaze.com/search/shows?q=%s"
+	}
+	return env
+}
+
+func (t TvMazeClient) showURLTemplate() string {
+	env := os.Getenv("TVMAZE_SHOW_URL_TEMPLATE")
+	if env == "" {
+		return "http://api.tvmaze.com/shows/%d?embed=episodes"
+	}
+	return env
+}
+
+// pinnedProvider is TVMaze for a show directory pinned to a TVMaze ID. It
+// trusts the pin and doesn't compare names.
+type pinnedProvider struct {
+	TvMazeClient
+	id int64
+}
+
+func (p pinnedProvider) SearchShow(dir string) (*Show, error) {
+	tvMazeShow, err := p.Lookup(p.id)
+	if err != nil {
+		return nil, err
+	}
+
+	return tvMazeShow.convert(), nil
+}
+
+func (t TvMazeShow) convert() *Show {
+	show := &Show{
+		ID:      strconv.FormatInt(t.ID, 10),
+		Name:    t.Name,
+		Summary: t.Summary,
+		Image:   Image(t.Image),
+	}
+
+	if t.Embedded.Episodes != nil {
+		show.Episodes = convertTvMazeEpisodes(t.Embedded.Episodes)
+	}
+
+	return show
+}
+
+func convertTvMazeEpisodes(tvMazeEpisodes []TvMazeEpisode) []Episode {
+	episodes := []Episode{}
+	for _, episode := range tvMazeEpisodes {
+		episodes = append(episodes, Episode{
+			Season:  int(episode.Season),
+			Number:  int(episode.Episode),
+			Name:    episode.Name,
+			Summary: episode.Summary,
+			Image:   Image(episode.Image),
+			AirDate: episode.AirDate,
+		})
+	}
+
+	return episodes
+}
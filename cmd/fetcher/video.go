@@ -0,0 +1,50 @@
+package main
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// videoTypes are the MIME types of the containers browsers might play. These
+// aren't left to the mime package, its answers depend on the host.
+var videoTypes = map[string]string{
+	"webm": "video/webm",
+	"mp4":  "video/mp4",
+	"m4v":  "video/mp4",
+	"mkv":  "video/x-matroska",
+	"ogv":  "video/ogg",
+}
+
+// acceptedExtensions returns the video extensions from -extensions, most
+// preferred first.
+func acceptedExtensions() []string {
+	extensions := []string{}
+	for _, extension := range strings.Split(videoExtensions, ",") {
+		extension = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(extension), "."))
+		if extension != "" {
+			extensions = append(extensions, extension)
+		}
+	}
+	return extensions
+}
+
+// extensionRank returns the position of the extension of fileName in
+// extensions, or len(extensions) when it isn't accepted.
+func extensionRank(fileName string, extensions []string) int {
+	extension := strings.ToLower(strings.TrimPrefix(path.Ext(fileName), "."))
+	for i, accepted := range extensions {
+		if extension == accepted {
+			return i
+		}
+	}
+	return len(extensions)
+}
+
+func videoType(fileName string) string {
+	extension := strings.ToLower(path.Ext(fileName))
+	if videoType, ok := videoTypes[strings.TrimPrefix(extension, ".")]; ok {
+		return videoType
+	}
+	return mime.TypeByExtension(extension)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+)
+
+// allSeasons marks a show of which every season has to be regenerated.
+const allSeasons = -1
+
+// pendingChanges are the seasons to regenerate, by show directory.
+type pendingChanges map[string]map[int]bool
+
+// add records the season a changed file in the media root belongs to. When
+// that can't be told from the path the whole show is regenerated.
+func (p pendingChanges) add(name string) {
+	parts := strings.Split(path.Clean(name), "/")
+	base := parts[len(parts)-1]
+	if base == "index.html" || path.Ext(base) == ".json" || isStill(base) {
+		return
+	}
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") && part != pinFile {
+			return
+		}
+	}
+
+	dir := parts[0]
+	if isGeneratedDir(dir) {
+		return
+	}
+	if p[dir] == nil {
+		p[dir] = map[int]bool{}
+	}
+
+	seasonNumber := allSeasons
+	if len(parts) > 2 {
+		if patterns, err := seasonDirPatterns(); err == nil {
+			if number, ok := seasonDirNumber(parts[1], patterns); ok {
+				seasonNumber = number
+			}
+		}
+	} else if len(parts) == 2 {
+		if parsed, ok := parseFilename(base); ok && parsed.AirDate == "" {
+			seasonNumber = parsed.Season
+		}
+	}
+	p[dir][seasonNumber] = true
+}
+
+// watchedLibrary is what -watch regenerates shows.json from: the shows that
+// resolved and, for those whose lookup failed, how they were listed before.
+type watchedLibrary struct {
+	shows map[string]*show
+	kept  map[string]ShowInList
+}
+
+func newWatchedLibrary() *watchedLibrary {
+	return &watchedLibrary{
+		shows: map[string]*show{},
+		kept:  map[string]ShowInList{},
+	}
+}
+
+// listing is the content of shows.json, sorted by directory.
+func (l *watchedLibrary) listing() []ShowInList {
+	dirs := []string{}
+	for dir := range l.shows {
+		dirs = append(dirs, dir)
+	}
+	for dir := range l.kept {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	shows := []ShowInList{}
+	for _, dir := range dirs {
+		if show, ok := l.shows[dir]; ok {
+			shows = append(shows, convertToShowInList(show))
+			continue
+		}
+		shows = append(shows, l.kept[dir])
+	}
+	return shows
+}
+
+// watchLibrary keeps regenerating the shows in the media root as videos are
+// added, renamed or removed. It never returns.
+func watchLibrary(library *watchedLibrary) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.WithField("err", err).Fatal("Error starting watcher")
+	}
+	defer watcher.Close()
+
+	if err := watchTree(watcher, "."); err != nil {
+		log.WithField("err", err).Fatal("Error watching media path")
+	}
+	log.Info("Watching media path for changes")
+
+	changes := pendingChanges{}
+	timer := time.NewTimer(watchDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			log.WithFields(log.Fields{
+				"file": event.Name,
+				"op":   event.Op.String(),
+			}).Debug("Media path changed")
+
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, event.Name); err != nil {
+						log.WithField("err", err).Error("Error watching new directory")
+					}
+				}
+			}
+
+			changes.add(event.Name)
+			// Wait for the changes to settle, copying a season takes a while.
+			timer.Reset(watchDelay)
+
+		case err := <-watcher.Errors:
+			log.WithField("err", err).Error("Error watching media path")
+
+		case <-timer.C:
+			applyChanges(library, changes)
+			changes = pendingChanges{}
+		}
+	}
+}
+
+// watchTree watches dir and every directory below it. fsnotify doesn't
+// recurse by itself.
+func watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if name != "." && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(name)
+	})
+}
+
+// applyChanges regenerates the changed seasons and drops what has gone from
+// disk. A show whose lookup fails stays listed as it was.
+func applyChanges(library *watchedLibrary, changes pendingChanges) {
+	scans.reset()
+
+	for dir, seasons := range changes {
+		contextLogger := log.WithField("dir", dir)
+
+		previous := library.shows[dir]
+		_, kept := library.kept[dir]
+
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			delete(library.shows, dir)
+			delete(library.kept, dir)
+			if previous == nil && !kept {
+				continue
+			}
+			contextLogger.Info("Show removed")
+			if outputDir != "" {
+				os.RemoveAll(outputPath(dir))
+			}
+			continue
+		}
+
+		show, err := resolveShow(dir)
+		if err != nil {
+			contextLogger.WithField("err", err).Error("Lookup failed, keeping the show as it was")
+			continue
+		}
+		delete(library.kept, dir)
+		if show == nil {
+			delete(library.shows, dir)
+			contextLogger.Info("Show doesn't match")
+			continue
+		}
+		localizeShowArtwork(show)
+		library.shows[dir] = show
+
+		writeShow(show)
+		for _, number := range changedSeasons(previous, show, seasons) {
+			writeSeason(number, show)
+			writeSeasonEpisodes(number, show)
+			pruneSeason(number, show)
+		}
+		contextLogger.Info("Show regenerated")
+	}
+
+	writeShows(library.listing())
+
+	if err := cache.save(); err != nil {
+		log.WithField("err", err).Error("Error saving cache")
+	}
+}
+
+// changedSeasons are the seasons to regenerate. With allSeasons that's every
+// season the show has now or had before.
+func changedSeasons(previous, current *show, changed map[int]bool) []int {
+	numbers := []int{}
+	if !changed[allSeasons] {
+		for number := range changed {
+			numbers = append(numbers, number)
+		}
+	} else {
+		numbers = append(numbers, seasons(current)...)
+		if previous != nil {
+			numbers = append(numbers, seasons(previous)...)
+		}
+	}
+
+	numbers = unique(numbers)
+	sort.Ints(numbers)
+	return numbers
+}
+
+// pruneSeason deletes the generated episode directories of a season whose
+// video is gone, and the season pages when nothing of it is left on disk.
+// Only directories holding an episode.json are considered generated.
+func pruneSeason(seasonNumber int, show *show) {
+	seasonDir := outputPath(seasonOutputDir(show.path, seasonNumber))
+
+	current := map[string]bool{}
+	if hasSeason(show, seasonNumber) {
+		for _, episode := range episodes(seasonNumber, show) {
+			current[urlify(episode.Name)] = true
+		}
+	}
+
+	files, err := ioutil.ReadDir(seasonDir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if !file.IsDir() || current[file.Name()] {
+			continue
+		}
+
+		episodeDir := path.Join(seasonDir, file.Name())
+		if _, err := os.Stat(path.Join(episodeDir, "episode.json")); err != nil {
+			continue
+		}
+
+		if err := os.RemoveAll(episodeDir); err != nil {
+			log.WithField("err", err).Error("Error removing stale episode")
+			continue
+		}
+		log.WithField("dir", episodeDir).Info("Stale episode removed")
+	}
+
+	if len(current) == 0 {
+		os.Remove(path.Join(seasonDir, "season.json"))
+		os.Remove(path.Join(seasonDir, "index.html"))
+		// Only goes when nothing else is left in it.
+		os.Remove(seasonDir)
+	}
+}
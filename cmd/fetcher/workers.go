@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// showResult is what processing a show directory came to. err is only set
+// when processing failed, a show that doesn't match is nil.
+type showResult struct {
+	dir  string
+	show *show
+	err  error
+}
+
+// processShows runs process for every show directory on -workers goroutines.
+// The results are in the order of dirs, whatever order the workers finish
+// in, and a show that fails doesn't take the others down.
+func processShows(dirs []string, process func(dir string) (*show, error)) []showResult {
+	results := make([]showResult, len(dirs))
+	jobs := make(chan int)
+
+	count := workers
+	if count < 1 {
+		count = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results[job] = processShow(dirs[job], process)
+			}
+		}()
+	}
+
+	for job := range dirs {
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func processShow(dir string, process func(dir string) (*show, error)) (result showResult) {
+	result.dir = dir
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithFields(log.Fields{
+				"dir":   dir,
+				"err":   r,
+				"stack": string(debug.Stack()),
+			}).Error("Failed to process show")
+			result.show = nil
+			result.err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	result.show, result.err = process(dir)
+	return result
+}
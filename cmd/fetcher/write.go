@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := json.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeFile writes data to fileName unless the file already holds exactly
+// that content. It reports whether the file was touched.
+func writeFile(fileName string, data []byte) (bool, error) {
+	existing, err := ioutil.ReadFile(fileName)
+	if err == nil && bytes.Equal(existing, data) {
+		return false, nil
+	}
+
+	if err := writeAtomic(fileName, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeAtomic writes data to a temporary file next to fileName and renames
+// it into place, so a browser loading during a run sees either the old or
+// the new file and never half of one. The temporary file is hidden, it isn't
+// served or watched.
+func writeAtomic(fileName string, data []byte) error {
+	dir := path.Dir(fileName)
+	temp, err := ioutil.TempFile(dir, "."+path.Base(fileName)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if fsyncWrites {
+		if err := temp.Sync(); err != nil {
+			temp.Close()
+			return err
+		}
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	// TempFile only lets the owner read.
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), fileName); err != nil {
+		return err
+	}
+
+	if fsyncWrites {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir survive a crash.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// outputPath is where a generated file goes: under -output when given, next
+// to the videos otherwise. name is relative to the media path.
+func outputPath(name string) string {
+	if outputDir == "" || path.IsAbs(name) {
+		return name
+	}
+	return path.Join(outputDir, name)
+}
+
+// makeOutputDir creates a directory of the generated site.
+func makeOutputDir(name string) error {
+	return os.MkdirAll(outputPath(name), 0755)
+}
+
+// videoURL is the URL of a video file, relative to the media path.
+func videoURL(fileName string) string {
+	if mediaURL != "" {
+		return mediaURL + fileName
+	}
+	return documentRoot + fileName
+}